@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assertion checks a response of an action. A request fails if any of the
+// assertions fails, and the failure is counted by the name of it.
+//
+//	assert:
+//	    - status: [200, 201, "300-399"]      # or 200, "2xx"
+//	    - header: Content-Type
+//	      contains: json                    # or equals, none for existence
+//	    - body_contains: '"ok"'
+//	    - body_not_contains: error
+//	    - json: $.status
+//	      equals: active                    # none for existence
+//	    - body_size: {min: 10, max: 10000}
+//	    - max_latency: 300ms
+//	      name: fast enough                 # optional, default is generated
+//
+// Expected strings may contain %(name)%.
+type Assertion struct {
+	Name string
+
+	status     [][2]int
+	header     string
+	json       *JSONPath
+	equals     *string
+	contains   *string
+	body       *string
+	bodyNot    *string
+	minSize    int
+	maxSize    int
+	sizeCheck  bool
+	maxLatency time.Duration
+}
+
+func (a *Assertion) HasStatus() bool {
+	return len(a.status) >= 1
+}
+
+func (a *Assertion) checkValue(v string, sc *Scope) bool {
+	if a.equals != nil && v != sc.Replace(*a.equals) {
+		return false
+	}
+	if a.contains != nil && !strings.Contains(v, sc.Replace(*a.contains)) {
+		return false
+	}
+	return true
+}
+
+// StatusOK returns true if the assertion has no status or code is in it
+func (a *Assertion) StatusOK(code int) bool {
+	if len(a.status) == 0 {
+		return true
+	}
+	for _, st := range a.status {
+		if st[0] <= code && code <= st[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns true if the response satisfies the assertion
+func (a *Assertion) Check(r *Response, sc *Scope) bool {
+	if !a.StatusOK(r.StatusCode) {
+		return false
+	}
+
+	if a.header != "" {
+		values := r.Header.Values(a.header)
+		if len(values) == 0 {
+			return false
+		}
+		if !a.checkValue(strings.Join(values, ", "), sc) {
+			return false
+		}
+	}
+
+	if a.json != nil {
+		doc, err := r.JSON()
+		if err != nil {
+			return false
+		}
+		nodes := a.json.Find(doc)
+		if len(nodes) == 0 {
+			return false
+		}
+		found := false
+		for _, n := range nodes {
+			if a.checkValue(JSONString(n), sc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if a.body != nil && !strings.Contains(string(r.Body()), sc.Replace(*a.body)) {
+		return false
+	}
+	if a.bodyNot != nil && strings.Contains(string(r.Body()), sc.Replace(*a.bodyNot)) {
+		return false
+	}
+
+	if a.sizeCheck {
+		size := len(r.Body())
+		if size < a.minSize || a.maxSize >= 0 && size > a.maxSize {
+			return false
+		}
+	}
+
+	if a.maxLatency > 0 && r.Time > a.maxLatency {
+		return false
+	}
+
+	return true
+}
+
+// 200, "2xx", "300-399"
+func parseStatusRange(v interface{}) (ret [2]int, err error) {
+	s := fmt.Sprint(v)
+	switch {
+	case len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx"):
+		n, err := strconv.Atoi(s[:1])
+		if err != nil {
+			return ret, fmt.Errorf("invalid status: %s", s)
+		}
+		return [2]int{n * 100, n*100 + 99}, nil
+	case strings.Contains(s, "-"):
+		ss := strings.SplitN(s, "-", 2)
+		from, err1 := strconv.Atoi(strings.TrimSpace(ss[0]))
+		to, err2 := strconv.Atoi(strings.TrimSpace(ss[1]))
+		if err1 != nil || err2 != nil || from > to {
+			return ret, fmt.Errorf("invalid status: %s", s)
+		}
+		return [2]int{from, to}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return ret, fmt.Errorf("invalid status: %s", s)
+	}
+	return [2]int{n, n}, nil
+}
+
+func stringOption(opts map[string]interface{}, key string) (*string, error) {
+	v, ok := opts[key]
+	if !ok {
+		return nil, nil
+	}
+	switch v.(type) {
+	case map[interface{}]interface{}, []interface{}:
+		return nil, fmt.Errorf("%s must be a scalar", key)
+	}
+	s := fmt.Sprint(v)
+	return &s, nil
+}
+
+func compileAssertion(spec interface{}) (*Assertion, error) {
+	opts, err := toStringMap(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Assertion{maxSize: -1}
+	names := []string{}
+	for k, v := range opts {
+		switch k {
+		case "name":
+			a.Name = fmt.Sprint(v)
+		case "status":
+			list, ok := v.([]interface{})
+			if !ok {
+				list = []interface{}{v}
+			}
+			for _, st := range list {
+				r, err := parseStatusRange(st)
+				if err != nil {
+					return nil, err
+				}
+				a.status = append(a.status, r)
+			}
+			names = append(names, fmt.Sprintf("status %v", v))
+		case "header":
+			a.header = fmt.Sprint(v)
+		case "json":
+			if a.json, err = CompileJSONPath(fmt.Sprint(v)); err != nil {
+				return nil, err
+			}
+		case "equals", "contains":
+			// used with header or json
+		case "body_contains":
+			if a.body, err = stringOption(opts, k); err != nil {
+				return nil, err
+			}
+			names = append(names, fmt.Sprintf("body contains %q", *a.body))
+		case "body_not_contains":
+			if a.bodyNot, err = stringOption(opts, k); err != nil {
+				return nil, err
+			}
+			names = append(names, fmt.Sprintf("body not contains %q", *a.bodyNot))
+		case "body_size":
+			size, err := toStringMap(v)
+			if err != nil {
+				return nil, fmt.Errorf("body_size %v", err)
+			}
+			a.sizeCheck = true
+			for key, bound := range size {
+				n, ok := bound.(int)
+				if !ok || n < 0 {
+					return nil, fmt.Errorf("body_size %s must be a non-negative integer: %v", key, bound)
+				}
+				switch key {
+				case "min":
+					a.minSize = n
+				case "max":
+					a.maxSize = n
+				default:
+					return nil, fmt.Errorf("body_size: unknown option %s", key)
+				}
+			}
+			if a.maxSize >= 0 && a.minSize > a.maxSize {
+				return nil, fmt.Errorf("body_size: min %d > max %d", a.minSize, a.maxSize)
+			}
+			names = append(names, fmt.Sprintf("body size %v", v))
+		case "max_latency":
+			if a.maxLatency, err = time.ParseDuration(fmt.Sprint(v)); err != nil {
+				return nil, fmt.Errorf("max_latency: %v", err)
+			}
+			names = append(names, fmt.Sprintf("latency <= %v", a.maxLatency))
+		default:
+			return nil, fmt.Errorf("unknown option %s", k)
+		}
+	}
+
+	if a.equals, err = stringOption(opts, "equals"); err != nil {
+		return nil, err
+	}
+	if a.contains, err = stringOption(opts, "contains"); err != nil {
+		return nil, err
+	}
+	if (a.equals != nil || a.contains != nil) && a.header == "" && a.json == nil {
+		return nil, fmt.Errorf("equals/contains is for header or json")
+	}
+	if a.header != "" && a.json != nil {
+		return nil, fmt.Errorf("header and json can not be used together")
+	}
+
+	if a.header != "" || a.json != nil {
+		target := "header " + a.header
+		if a.json != nil {
+			target = "json " + a.json.Expr
+		}
+		switch {
+		case a.equals != nil:
+			target += fmt.Sprintf(" == %q", *a.equals)
+		case a.contains != nil:
+			target += fmt.Sprintf(" contains %q", *a.contains)
+		default:
+			target += " exists"
+		}
+		names = append(names, target)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("empty assertion")
+	}
+	if a.Name == "" {
+		a.Name = strings.Join(names, ", ")
+	}
+
+	return a, nil
+}
+
+func compileAssertions(spec interface{}) ([]*Assertion, error) {
+	list, ok := spec.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("assert must be a list")
+	}
+
+	assertions := []*Assertion{}
+	for i, v := range list {
+		a, err := compileAssertion(v)
+		if err != nil {
+			return nil, fmt.Errorf("assert[%d]: %v", i, err)
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions, nil
+}
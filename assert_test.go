@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestAssertion(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	resp := &Response{
+		Response: &http.Response{StatusCode: 201, Header: header},
+		Raw:      []byte(`{"status": "ok", "items": [{"id": 1}, {"id": 2}]}`),
+		Time:     50 * time.Millisecond,
+	}
+	sc := NewScope(map[string]int{})
+	sc.Set("want", "ok", false)
+
+	cases := []struct {
+		spec string
+		want bool
+	}{
+		{`status: 201`, true},
+		{`status: [200, 204]`, false},
+		{`status: 2xx`, true},
+		{`status: "200-299"`, true},
+		{`header: Content-Type`, true},
+		{`{header: Content-Type, contains: json}`, true},
+		{`{header: Content-Type, equals: json}`, false},
+		{`header: X-Nothing`, false},
+		{`body_contains: '"ok"'`, true},
+		{`body_not_contains: error`, true},
+		{`body_not_contains: status`, false},
+		{`{json: $.status, equals: "%(want)%"}`, true},
+		{`{json: "$.items[*].id", equals: 2}`, true},
+		{`{json: "$.items[*].id", equals: 3}`, false},
+		{`json: $.nothing`, false},
+		{`body_size: {min: 10}`, true},
+		{`body_size: {max: 10}`, false},
+		{`max_latency: 100ms`, true},
+		{`max_latency: 10ms`, false},
+	}
+	for _, tt := range cases {
+		var spec interface{}
+		if err := yaml.Unmarshal([]byte(tt.spec), &spec); err != nil {
+			t.Fatal(err)
+		}
+		a, err := compileAssertion(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		if ret := a.Check(resp, sc); ret != tt.want {
+			t.Fatalf("%s (%s) invalid result: want=%v, ret=%v", tt.spec, a.Name, tt.want, ret)
+		}
+	}
+
+	for _, spec := range []string{`equals: x`, `status: abc`, `foo: 1`, `{}`,
+		`body_contains: [a, b]`, `body_not_contains: {a: b}`,
+		`body_size: {min: 10k}`, `body_size: {max: 1.5}`, `body_size: {min: "10"}`,
+		`body_size: {min: -1}`, `body_size: {min: 10, max: 5}`, `body_size: {size: 1}`} {
+		var v interface{}
+		yaml.Unmarshal([]byte(spec), &v)
+		if _, err := compileAssertion(v); err == nil {
+			t.Fatalf("%s: want error", spec)
+		}
+	}
+}
+
+func TestAttackerStatusOK(t *testing.T) {
+	specs := map[string]string{
+		"none":    `[{body_contains: ok}]`,
+		"created": `[{status: 201}, {body_contains: ok}]`,
+		"both":    `[{status: [200, 201]}, {status: 201}]`,
+	}
+	cases := []struct {
+		spec    string
+		code    int
+		ok      bool
+		checked bool
+	}{
+		{"none", 200, true, false},
+		{"none", 500, false, false},
+		{"created", 201, true, true},
+		{"created", 200, false, true},
+		{"both", 200, false, true},
+		{"both", 201, true, true},
+	}
+	for _, tt := range cases {
+		var v interface{}
+		yaml.Unmarshal([]byte(specs[tt.spec]), &v)
+		assertions, err := compileAssertions(v)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		atk := &Attacker{Action: map[string]interface{}{"assert": assertions}}
+		if ok, checked := atk.statusOK(tt.code); ok != tt.ok || checked != tt.checked {
+			t.Fatalf("%s %d invalid result: ok=%v, checked=%v", tt.spec, tt.code, ok, checked)
+		}
+	}
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type Attacker struct {
+	Ctx         context.Context
 	Url         *url.URL
 	Client      *http.Client
 	Action      map[string]interface{}
@@ -21,12 +29,31 @@ type Attacker struct {
 	Gzip        bool
 	QueryParams *map[string]string
 	Headers     *map[string]string
-	ExVarOffset map[string]int
-	sync.RWMutex
+	Scope       *Scope
+	PathRules   []PathRule
+	// fail the request with unresolved placeholders
+	Strict bool
+	// any request of the scenario failed
+	Failed bool
+	// scheme://host of an absolute path of the action (like Location of a
+	// redirect) to another host than the domain
+	origin string
+}
+
+// error for unresolved placeholders in strict mode
+func (atk *Attacker) unresolved() error {
+	if atk.Strict && len(atk.Scope.Unresolved) >= 1 {
+		return &unresolvedError{names: atk.Scope.Unresolved}
+	}
+	return nil
 }
 
 func (atk *Attacker) makeRequest() (req *http.Request, err error) {
-	checkPath := ReplaceNames(atk.Action["path"].(string), atk.ExVarOffset)
+	atk.Scope.Unresolved = nil
+	checkPath := atk.Scope.Replace(atk.Action["path"].(string))
+	if err := atk.unresolved(); err != nil {
+		return nil, err
+	}
 	checkUrl, err := url.Parse(checkPath)
 	if err != nil {
 		log.Printf("url.Parse() Error: %v\n", err)
@@ -34,6 +61,13 @@ func (atk *Attacker) makeRequest() (req *http.Request, err error) {
 	}
 
 	atk.Url.Path = checkUrl.Path
+	reqUrl := *atk.Url
+	atk.origin = ""
+	if checkUrl.Host != "" && (checkUrl.Host != atk.Url.Host || checkUrl.Scheme != atk.Url.Scheme) {
+		reqUrl.Scheme = checkUrl.Scheme
+		reqUrl.Host = checkUrl.Host
+		atk.origin = checkUrl.Scheme + "://" + checkUrl.Host
+	}
 
 	method, ret := atk.Action["method"]
 	if !ret {
@@ -45,16 +79,23 @@ func (atk *Attacker) makeRequest() (req *http.Request, err error) {
 	postParams, retPostParams := atk.Action["post_params"]
 	if method == "POST" && retPostParams {
 		for k, v := range postParams.(map[interface{}]interface{}) {
-			values.Add(k.(string), ReplaceNames(v.(string), atk.ExVarOffset))
+			values.Add(k.(string), atk.Scope.Replace(v.(string)))
 		}
 		content = strings.NewReader(values.Encode())
+	} else if name, ok := atk.Action["content_from"].(string); ok {
+		// the bound record of the data source as it is
+		row, ok := atk.Scope.Rows[name]
+		if !ok {
+			return nil, fmt.Errorf("content_from: no record of %s", name)
+		}
+		content = strings.NewReader(DATA[name].Content(row))
 	} else {
 		if _content, ret := atk.Action["content"]; ret {
-			content = strings.NewReader(ReplaceNames(_content.(string), atk.ExVarOffset))
+			content = strings.NewReader(atk.Scope.Replace(_content.(string)))
 		}
 	}
 
-	req, err = http.NewRequest(method.(string), atk.Url.String(), content)
+	req, err = http.NewRequestWithContext(atk.Ctx, method.(string), reqUrl.String(), content)
 	if err != nil {
 		log.Printf("NewRequest Error: %v\n", err)
 		return nil, err
@@ -64,6 +105,8 @@ func (atk *Attacker) makeRequest() (req *http.Request, err error) {
 		req.Header.Set("Content-Type", contentType.(string))
 	} else if method == "POST" && retPostParams {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else if _, ok := atk.Action["content_from"]; ok {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
 	if atk.Gzip {
@@ -76,28 +119,181 @@ func (atk *Attacker) makeRequest() (req *http.Request, err error) {
 	for k, v := range checkUrl.Query() {
 		values.Add(k, v[0])
 	}
+	// the common ones of the config, resolved at the start of the scenario
+	// except the names unknown then like extracted ones, and the action's
 	for k, v := range *atk.QueryParams {
-		values.Add(k, v)
+		values.Add(k, atk.Scope.Replace(v))
+	}
+	if queryParams, ok := atk.Action["query_params"].(map[string]string); ok {
+		for k, v := range queryParams {
+			values.Set(k, atk.Scope.Replace(v))
+		}
 	}
 	req.URL.RawQuery = values.Encode()
 
 	for k, v := range *atk.Headers {
-		req.Header.Add(k, v)
+		req.Header.Add(k, atk.Scope.Replace(v))
+	}
+	if headers, ok := atk.Action["headers"].(map[string]string); ok {
+		for k, v := range headers {
+			req.Header.Set(k, atk.Scope.Replace(v))
+		}
 	}
 
 	req.Header.Set("User-Agent", atk.UserAgent)
-	return req, err
+	return req, atk.unresolved()
+}
+
+// store the values of "extract" into the scope. return the failure reason
+// when a required value is missing.
+func (atk *Attacker) extract(resp *Response) string {
+	extractors, ok := atk.Action["extract"].([]*Extractor)
+	if !ok {
+		return ""
+	}
+
+	global, _ := atk.Action["global"].(bool)
+	for _, e := range extractors {
+		values, err := e.Extract(resp)
+		if err != nil {
+			if e.Required {
+				return fmt.Sprintf("extract %s: %v", e.Name, err)
+			}
+			continue
+		}
+		atk.Scope.SetValues(e.Name, values, global)
+	}
+
+	return ""
+}
+
+// check the "assert" of the action. return the failure reasons.
+func (atk *Attacker) assert(resp *Response) (reasons []string) {
+	assertions, _ := atk.Action["assert"].([]*Assertion)
+	for _, a := range assertions {
+		if !a.Check(resp, atk.Scope) {
+			reasons = append(reasons, "assert "+a.Name)
+		}
+	}
+	return reasons
+}
+
+// whether the status is expected by the assertions of the action, or 2xx
+// when no assertion checks the status
+func (atk *Attacker) statusOK(code int) (ok, checked bool) {
+	assertions, _ := atk.Action["assert"].([]*Assertion)
+	ok = true
+	for _, a := range assertions {
+		if a.HasStatus() {
+			checked = true
+			ok = ok && a.StatusOK(code)
+		}
+	}
+	if !checked {
+		ok = 200 <= code && code <= 299
+	}
+	return ok, checked
 }
 
-func wrapRegexp(s string) interface{} {
-	return regexp.MustCompile(s)
+// validate the body by "json_schema" of the action. return the failure
+// reason, which is the same for all violations of the schema.
+func (atk *Attacker) validate(resp *Response) string {
+	schema, ok := atk.Action["json_schema"].(*JSONSchema)
+	if !ok {
+		return ""
+	}
+
+	doc, err := resp.JSON()
+	if err == nil {
+		err = schema.Validate(doc)
+	}
+	if err != nil {
+		if verbose {
+			log.Printf("json_schema %s: %v\n", schema.Name, err)
+		}
+		return "json_schema " + schema.Name
+	}
+	return ""
 }
 
-func (atk *Attacker) Attack() {
+// key of the statistics. the name of the action, or the normalized path
+func (atk *Attacker) statsKey() string {
+	if name, ok := atk.Action["name"].(string); ok && name != "" {
+		return name
+	}
+
+	path := atk.Url.Path
+	for _, rule := range atk.PathRules {
+		path = rule.re.ReplaceAllString(path, rule.Replace)
+	}
+	return atk.origin + path
+}
+
+func decodeBody(raw []byte, encoding string) []byte {
+	switch encoding {
+	case "gzip", "deflate":
+		reader, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return raw
+		}
+		defer reader.Close()
+		body, _ := io.ReadAll(reader)
+		return body
+	default:
+		return raw
+	}
+}
+
+// notify the result to the indicator. diffTime is 0 when no response,
+// reasons are the causes of the failure. success is returned as it is.
+func (atk *Attacker) done(success bool, diffTime time.Duration, reasons ...string) bool {
+	if !success {
+		atk.Failed = true
+	}
+	recordTick(success, diffTime)
+	recordResult(atk.statsKey(), success, reasons)
+	ok <- success
+	return success
+}
+
+// short description of the request error for the statistics. the set of
+// reasons is fixed, since the messages contain addresses like ephemeral
+// ports.
+func errorReason(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE):
+		return "connection reset"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		errors.As(err, &recordErr) || strings.Contains(err.Error(), "tls: "):
+		return "tls"
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	}
+	return "other"
+}
+
+// send the request of the action and record the result. return whether it
+// succeeded.
+func (atk *Attacker) Attack() bool {
 	req, err := atk.makeRequest()
 	if err != nil {
-		ok <- false
-		return
+		var unresolved *unresolvedError
+		if errors.As(err, &unresolved) {
+			return atk.done(false, 0, err.Error())
+		}
+		return atk.done(false, 0, "invalid request")
 	}
 
 	if verbose {
@@ -108,78 +304,87 @@ func (atk *Attacker) Attack() {
 		}
 	}
 
+	trace := &requestTrace{}
+	req = trace.WithTrace(req)
+
 	t0 := time.Now()
+	trace.start = t0
 	res, err := atk.Client.Do(req)
+	if err != nil && atk.Ctx.Err() != nil {
+		// canceled by the end of the run, not a failure
+		atomic.AddUint32(&INTERRUPTED, 1)
+		return false
+	}
 	if err != nil {
 		log.Printf("request error: %v\n", err)
-		ok <- false
-		return
+		return atk.done(false, 0, errorReason(err))
 	}
 	defer res.Body.Close()
 
 	t1 := time.Now()
 	diffTime := t1.Sub(t0)
 
-	validRes := true
-	atk.RLock()
-	_scan, ret := atk.Action["scan"]
-	atk.RUnlock()
-	if ret {
-		// check body text
-		var reader io.ReadCloser
-		switch res.Header.Get("Content-Encoding") {
-		case "gzip", "deflate":
-			reader, _ = gzip.NewReader(res.Body)
-			defer reader.Close()
-		default:
-			reader = res.Body
-		}
-		body, _ := io.ReadAll(reader)
-
-		// memoization
-		var scan *regexp.Regexp
-		_s, _ok := _scan.(string)
-		if _ok {
-			atk.Lock()
-			atk.Action["scan"] = wrapRegexp(_s)
-			atk.Unlock()
-		}
-		atk.RLock()
-		scan = atk.Action["scan"].(*regexp.Regexp)
-		atk.RUnlock()
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		log.Println(err)
+	}
+	trace.set(&trace.bodyDone)
 
+	resp := &Response{Response: res, Raw: raw, Time: diffTime, Jar: atk.Client.Jar}
+	reasons := []string{}
+	if scan, ok := atk.Action["scan"].(*regexp.Regexp); ok {
+		// check body text
+		body := resp.Body()
 		if scan.Match(body) {
+			global, _ := atk.Action["global"].(bool)
 			names := scan.SubexpNames()
 			for _, tname := range scan.FindAllStringSubmatch(string(body), -1) {
 				for i, name := range tname[1:] {
-					VARS_MUTEX.Lock()
-					SCANNED_VARS[names[i+1]] = name
-					VARS_MUTEX.Unlock()
+					atk.Scope.Set(names[i+1], name, global)
 				}
 			}
 		} else {
-			validRes = false
-			log.Println(atk.Url)
-			fmt.Print(string(body))
+			reasons = append(reasons, "scan: not matched")
+			if verbose {
+				log.Printf("scan not matched: %s\n%s\n", atk.Url, body)
+			}
 		}
-	} else {
-		if _, err := io.ReadAll(res.Body); err != nil {
-			log.Println(err)
+	}
+
+	// error pages are not extracted, the status is the reason
+	statusOK, statusChecked := atk.statusOK(res.StatusCode)
+	if len(reasons) == 0 && statusOK {
+		if reason := atk.extract(resp); reason != "" {
+			reasons = append(reasons, reason)
 		}
 	}
 
+	reasons = append(reasons, atk.assert(resp)...)
+	if reason := atk.validate(resp); reason != "" {
+		reasons = append(reasons, reason)
+	}
+
 	if verbose {
 		log.Println(diffTime, res.StatusCode, res.ContentLength)
 	}
 
+	key := atk.statsKey()
 	m.Lock()
-	PathCount[atk.Url.Path] += 1
-	PathTime[atk.Url.Path] += diffTime
+	PathCount[key] += 1
+	PathTime[key] += diffTime
+	if _, ok := PathHist[key]; !ok {
+		PathHist[key] = NewHistogram()
+	}
+	PathHist[key].Record(diffTime)
+	if _, ok := PathPhase[key]; !ok {
+		PathPhase[key] = NewPhaseStats()
+	}
+	PathPhase[key].Record(trace)
+	StatusCount[res.StatusCode] += 1
 	m.Unlock()
 
-	if validRes && res.StatusCode/10 == 20 {
-		ok <- true
-	} else {
-		ok <- false
+	if !statusChecked && !statusOK {
+		reasons = append(reasons, fmt.Sprintf("status %d", res.StatusCode))
 	}
+	return atk.done(len(reasons) == 0, diffTime, reasons...)
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestErrorReason(t *testing.T) {
+	opErr := func(errno error) error {
+		return &url.Error{Op: "Get", URL: "http://localhost:8000/", Err: &net.OpError{
+			Op: "read", Net: "tcp",
+			Source: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 54321},
+			Addr:   &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8000},
+			Err:    &os.SyscallError{Syscall: "read", Err: errno},
+		}}
+	}
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{opErr(syscall.ECONNRESET), "connection reset"},
+		{opErr(syscall.ECONNREFUSED), "connection refused"},
+		{opErr(syscall.EPIPE), "connection reset"},
+		{&url.Error{Op: "Get", URL: "http://a/", Err: context.DeadlineExceeded}, "timeout"},
+		{&url.Error{Op: "Get", URL: "http://a/", Err: &net.DNSError{Err: "no such host", Name: "a"}}, "dns"},
+		{&url.Error{Op: "Get", URL: "https://a/", Err: errors.New("remote error: tls: handshake failure")}, "tls"},
+		{&url.Error{Op: "Get", URL: "http://a/", Err: io.EOF}, "eof"},
+		{errors.New("stopped after 10 redirects"), "other"},
+	}
+	for _, tt := range cases {
+		if ret := errorReason(tt.err); ret != tt.want {
+			t.Fatalf("%v invalid result: want=%s, ret=%s", tt.err, tt.want, ret)
+		}
+	}
+}
+
+func TestMakeRequestAbsolutePath(t *testing.T) {
+	u, _ := url.Parse("http://localhost:8000")
+	cases := []struct {
+		path string
+		url  string
+		key  string
+	}{
+		{"/a?x=1", "http://localhost:8000/a?x=1", "/a"},
+		{"http://localhost:8000/b", "http://localhost:8000/b", "/b"},
+		{"https://other.example.com/c", "https://other.example.com/c", "https://other.example.com/c"},
+		{"/d", "http://localhost:8000/d", "/d"},
+	}
+	atk := &Attacker{Ctx: context.Background(), Url: u, QueryParams: &map[string]string{},
+		Headers: &map[string]string{}, Scope: NewScope(map[string]int{})}
+	for _, tt := range cases {
+		atk.Action = map[string]interface{}{"path": tt.path}
+		req, err := atk.makeRequest()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if req.URL.String() != tt.url || atk.statsKey() != tt.key {
+			t.Fatalf("%s invalid result: url=%s, key=%s", tt.path, req.URL, atk.statsKey())
+		}
+	}
+}
+
+func TestMakeRequestConfigTemplates(t *testing.T) {
+	VARS = map[string][]string{"v1": {"a", "b", "c", "d", "e", "f", "g", "h"}}
+	defer func() { VARS = map[string][]string{} }()
+
+	// resolved at the start of the scenario like hakai
+	sc := NewScope(map[string]int{})
+	u, _ := url.Parse("http://localhost:8000")
+	atk := &Attacker{Ctx: context.Background(), Url: u, Scope: sc,
+		QueryParams: &map[string]string{},
+		Action:      map[string]interface{}{"path": "/"}}
+	headers := sc.ReplaceKnownMap(map[string]string{
+		"Authorization": "Bearer %(token:-anonymous)%",
+		"X-V1":          "%(v1|upper)%",
+		"X-Request":     "%(uuid())%",
+		"X-Trace":       "%(trace)%",
+	})
+	atk.Headers = &headers
+
+	req1, err := atk.makeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret := req1.Header.Get("Authorization"); ret != "Bearer anonymous" {
+		t.Fatalf("invalid header: %s", ret)
+	}
+
+	// extracted after the start of the scenario
+	sc.Set("token", "abc", false)
+	for i := 0; i < 10; i++ {
+		req2, _ := atk.makeRequest()
+		if ret := req2.Header.Get("Authorization"); ret != "Bearer abc" {
+			t.Fatalf("invalid header: %s", ret)
+		}
+		for _, name := range []string{"X-V1", "X-Request"} {
+			if req1.Header.Get(name) != req2.Header.Get(name) {
+				t.Fatalf("%s is not a value per scenario: %s, %s", name, req1.Header.Get(name), req2.Header.Get(name))
+			}
+		}
+	}
+
+	atk.Strict = true
+	if _, err := atk.makeRequest(); err == nil || err.Error() != "unresolved %(trace)%" {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
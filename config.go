@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/gob"
+	"fmt"
 	"log"
 	"math/rand"
 	"os"
@@ -24,73 +25,152 @@ var CONFIG_ROOT string
 var CONSTS map[string]string
 var EXVARS map[string]*ExVer
 var VARS map[string][]string
-var SCANNED_VARS map[string]string
+var SCANNED_VARS map[string][]string
+var SCHEMAS map[string][]byte
 var NODES []Node
 var re *regexp.Regexp = regexp.MustCompile(`%\((.+?)\)%`)
 var VARS_MUTEX sync.RWMutex
 
 type ExVer struct {
 	Value  []string
-	Offset int
+	Policy string
+	cursor
 }
 
 // for remote config
 type AllVars struct {
-	Vars   map[string][]string
-	ExVars map[string]*ExVer
+	Vars    map[string][]string
+	ExVars  map[string]*ExVer
+	Schemas map[string][]byte
+	Data    map[string]*DataSet
+	Share   Share
+}
+
+// PathRule rewrites the path of unnamed actions for statistics,
+// e.g. pattern: '/users/\d+', replace: '/users/:id'
+type PathRule struct {
+	Pattern string `yaml:"pattern"`
+	Replace string `yaml:"replace"`
+	re      *regexp.Regexp
 }
 
 type Config struct {
-	Domain      string                   `yaml:"domain"`
-	UserAgent   string                   `yaml:"user_agent"`
-	ShowReport  bool                     `yaml:"show_report"`
-	Gzip        bool                     `yaml:"gzip"`
-	Timeout     uint16                   `yaml:"timeout"`
-	Nodes       []map[string]interface{} `yaml:"nodes"`
-	Actions     []map[string]interface{} `yaml:"actions"`
-	QueryParams map[string]string        `yaml:"query_params"`
-	Consts      map[string]string        `yaml:"consts"`
-	ExVars      []map[string]string      `yaml:"exvars"`
-	Vars        []map[string]string      `yaml:"vars"`
-	Headers     map[string]string        `yaml:"headers"`
-	HTTPVersion int                      `yaml:"http_version"`
+	Domain       string                   `yaml:"domain"`
+	UserAgent    string                   `yaml:"user_agent"`
+	ShowReport   bool                     `yaml:"show_report"`
+	Gzip         bool                     `yaml:"gzip"`
+	Timeout      uint16                   `yaml:"timeout"`
+	Nodes        []map[string]interface{} `yaml:"nodes"`
+	Actions      []map[string]interface{} `yaml:"actions"`
+	QueryParams  map[string]string        `yaml:"query_params"`
+	Consts       map[string]string        `yaml:"consts"`
+	ExVars       []map[string]string      `yaml:"exvars"`
+	Vars         []map[string]string      `yaml:"vars"`
+	Headers      map[string]string        `yaml:"headers"`
+	HTTPVersion  int                      `yaml:"http_version"`
+	Rate         float64                  `yaml:"rate"`
+	RateUnit     string                   `yaml:"rate_unit"`
+	Stages       []Stage                  `yaml:"stages"`
+	GracefulStop string                   `yaml:"graceful_stop"`
+	PathRules    []PathRule               `yaml:"path_normalize"`
+	Thresholds   []string                 `yaml:"thresholds"`
+	ThinkTime    interface{}              `yaml:"think_time"`
+	Pacing       string                   `yaml:"pacing"`
+	Scenarios    []Scenario               `yaml:"scenarios"`
+	Strict       bool                     `yaml:"strict"`
+	Data         []DataSource             `yaml:"data"`
+
+	// drain in-flight requests up to this after -d, default is timeout
+	gracefulStop time.Duration
+	thresholds   []Threshold
+	thinkTime    *ThinkTime
+	// minimum duration of a scenario
+	pacing time.Duration
+	// Scenarios, or Actions as the default scenario
+	scenarios []Scenario
 }
 
+// replace %(name)% without the scenario scope
 func ReplaceNames(input string, offset map[string]int) string {
-	cb := func(s string) string {
-		tname := re.FindAllStringSubmatch(s, -1)
+	return NewScope(offset).Replace(input)
+}
 
-		for idx, t := range tname {
-			if c, ok := CONSTS[t[1]]; ok {
-				return c
-			}
+func toStringMap(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		ret := map[string]interface{}{}
+		for k, v := range m {
+			ret[fmt.Sprint(k)] = v
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("must be a map")
+}
 
-			if v, ok := VARS[t[1]]; ok {
-				return v[rand.Intn(len(v))]
+// validate and convert the options of actions in advance, so that they can
+// be used by Attack as they are (like "scan" is compiled to *regexp.Regexp)
+func compileActions(actions []map[string]interface{}) error {
+	for i, action := range actions {
+		if err := compileFlow(action); err != nil {
+			return fmt.Errorf("actions[%d]: %v", i, err)
+		}
+
+		if v, ok := action["extract"]; ok {
+			extractors, err := compileExtractors(v)
+			if err != nil {
+				return fmt.Errorf("actions[%d]: %v", i, err)
 			}
+			action["extract"] = extractors
+		}
 
-			if e, ok := EXVARS[t[1]]; ok {
-				_e := e.Value[offset[t[1]]]
-				return _e
+		if v, ok := action["scan"]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("actions[%d]: scan must be a string", i)
+			}
+			scan, err := regexp.Compile(s)
+			if err != nil {
+				return fmt.Errorf("actions[%d]: scan %v", i, err)
 			}
+			action["scan"] = scan
+		}
 
-			VARS_MUTEX.RLock()
-			s, ok := SCANNED_VARS[t[1]]
-			VARS_MUTEX.RUnlock()
-			if ok {
-				return s
+		if v, ok := action["think_time"]; ok {
+			t, err := compileThinkTime(v)
+			if err != nil {
+				return fmt.Errorf("actions[%d]: %v", i, err)
 			}
+			action["think_time"] = t
+		}
 
-			if idx == 0 {
-				return t[0]
+		if v, ok := action["assert"]; ok {
+			assertions, err := compileAssertions(v)
+			if err != nil {
+				return fmt.Errorf("actions[%d]: %v", i, err)
 			}
+			action["assert"] = assertions
 		}
 
-		return tname[0][0]
+		for _, key := range []string{"headers", "query_params"} {
+			v, ok := action[key]
+			if !ok {
+				continue
+			}
+			m, err := toStringMap(v)
+			if err != nil {
+				return fmt.Errorf("actions[%d]: %s %v", i, key, err)
+			}
+			ss := map[string]string{}
+			for k, v := range m {
+				ss[k] = fmt.Sprint(v)
+			}
+			action[key] = ss
+		}
 	}
-	ret := re.ReplaceAllStringFunc(input, cb)
 
-	return ret
+	return nil
 }
 
 func loadVarsFromFile(filename string) (lines []string) {
@@ -124,7 +204,11 @@ func loadVarsFromGobFile() {
 		log.Fatal("decode:", err)
 	}
 
+	VARS = v.Vars
 	EXVARS = v.ExVars
+	SCHEMAS = v.Schemas
+	DATA = v.Data
+	SHARE = v.Share
 }
 
 // dump gob file
@@ -145,11 +229,17 @@ func dumpVars(filename string, offset, procs, allProcs int) {
 			}
 		}
 
-		ex[key] = &ExVer{Value: newValue, Offset: 0}
+		ex[key] = &ExVer{Value: newValue, Policy: val.Policy}
 	}
 
 	// Create an encoder and send a value.
-	var v AllVars = AllVars{ExVars: ex, Vars: VARS}
+	var v AllVars = AllVars{
+		ExVars:  ex,
+		Vars:    VARS,
+		Schemas: SCHEMAS,
+		Data:    shardData(offset, procs, allProcs),
+		Share:   Share{Offset: offset, Procs: procs, AllProcs: allProcs},
+	}
 	enc := gob.NewEncoder(&buf)
 	err := enc.Encode(v)
 	if err != nil {
@@ -161,19 +251,74 @@ func dumpVars(filename string, offset, procs, allProcs int) {
 	}
 }
 
-func (c *Config) loadVars() {
+// vars with a policy other than random are loaded into EXVARS, to be bound
+// to a scenario and sharded to nodes
+func (c *Config) loadVars() error {
+	for i, v := range c.ExVars {
+		if _, err := parsePolicy(v["policy"], POLICY_SEQUENTIAL_WRAP); err != nil {
+			return fmt.Errorf("exvars[%d]: %v", i, err)
+		}
+	}
+	for i, v := range c.Vars {
+		if _, err := parsePolicy(v["policy"], POLICY_RANDOM); err != nil {
+			return fmt.Errorf("vars[%d]: %v", i, err)
+		}
+	}
+
 	if MODE_NORMAL != ExecMode {
 		// when remote execution (from gob file)
 		loadVarsFromGobFile()
 	} else {
 		// when local execution
 		for _, v := range c.ExVars {
-			EXVARS[v["name"]] = &ExVer{Value: loadVarsFromFile(v["file"])}
+			policy, _ := parsePolicy(v["policy"], POLICY_SEQUENTIAL_WRAP)
+			EXVARS[v["name"]] = &ExVer{Value: loadVarsFromFile(v["file"]), Policy: policy}
 		}
 		for _, v := range c.Vars {
+			policy, _ := parsePolicy(v["policy"], POLICY_RANDOM)
+			if policy != POLICY_RANDOM {
+				EXVARS[v["name"]] = &ExVer{Value: loadVarsFromFile(v["file"]), Policy: policy}
+				continue
+			}
 			VARS[v["name"]] = loadVarsFromFile(v["file"])
 		}
 	}
+	return nil
+}
+
+// compile "json_schema" of actions. the files are read from CONFIG_ROOT,
+// and shipped to nodes by the gob file.
+func (c *Config) loadSchemas() error {
+	for i, action := range c.allActions() {
+		v, ok := action["json_schema"]
+		if !ok {
+			continue
+		}
+		filename, ok := v.(string)
+		if !ok || filename == "" {
+			return fmt.Errorf("actions[%d]: json_schema must be a file name", i)
+		}
+
+		b, ok := SCHEMAS[filename]
+		if !ok {
+			if MODE_NORMAL != ExecMode {
+				return fmt.Errorf("actions[%d]: json_schema %s is not in %s", i, filename, GOB_FILE)
+			}
+			var err error
+			if b, err = os.ReadFile(filepath.Join(CONFIG_ROOT, filename)); err != nil {
+				return fmt.Errorf("actions[%d]: json_schema %v", i, err)
+			}
+			SCHEMAS[filename] = b
+		}
+
+		schema, err := CompileJSONSchema(b)
+		if err != nil {
+			return fmt.Errorf("actions[%d]: json_schema %s: %v", i, filename, err)
+		}
+		schema.Name = filename
+		action["json_schema"] = schema
+	}
+	return nil
 }
 
 func (c *Config) loadNodes() {
@@ -243,6 +388,7 @@ func (c *Config) Load(filename string) error {
 	rand.Seed(time.Now().Unix())
 	buf, err := os.ReadFile(filename)
 	if err != nil {
+		log.Printf("'%s' %v\n", filename, err)
 		return err
 	}
 
@@ -261,6 +407,63 @@ func (c *Config) Load(filename string) error {
 	if c.Domain == "" {
 		c.Domain = DEFALT_DOMAIN
 	}
+	if c.GracefulStop == "" {
+		c.gracefulStop = time.Duration(c.Timeout) * time.Second
+	} else if c.gracefulStop, err = time.ParseDuration(c.GracefulStop); err != nil {
+		log.Printf("'%s' graceful_stop: %v\n", filename, err)
+		return err
+	}
+	if c.RateUnit == "" {
+		c.RateUnit = RATE_UNIT_SCENARIO
+	}
+	if c.RateUnit != RATE_UNIT_SCENARIO && c.RateUnit != RATE_UNIT_REQUEST {
+		return fmt.Errorf("unknown rate_unit: %s", c.RateUnit)
+	}
+
+	if c.ThinkTime != nil {
+		if c.thinkTime, err = compileThinkTime(c.ThinkTime); err != nil {
+			log.Printf("'%s' %v\n", filename, err)
+			return err
+		}
+	}
+	if c.Pacing != "" {
+		if c.pacing, err = time.ParseDuration(c.Pacing); err != nil || c.pacing < 0 {
+			log.Printf("'%s' invalid pacing: %s\n", filename, c.Pacing)
+			return fmt.Errorf("invalid pacing: %s", c.Pacing)
+		}
+	}
+
+	if err = c.loadStages(); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
+
+	for i := range c.PathRules {
+		if c.PathRules[i].re, err = regexp.Compile(c.PathRules[i].Pattern); err != nil {
+			log.Printf("'%s' path_normalize: %v\n", filename, err)
+			return err
+		}
+	}
+
+	if err = c.loadScenarios(); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
+
+	if err = compileActions(c.allActions()); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
+
+	if err = c.checkFilters(); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
+
+	if err = c.loadThresholds(); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
 
 	CONFIG_ROOT = filepath.Dir(filename)
 
@@ -268,9 +471,22 @@ func (c *Config) Load(filename string) error {
 	VARS = map[string][]string{}
 	EXVARS = map[string]*ExVer{}
 	CONSTS = c.Consts
-	SCANNED_VARS = map[string]string{}
+	SCANNED_VARS = map[string][]string{}
+	SCHEMAS = map[string][]byte{}
+	DATA = map[string]*DataSet{}
 
-	c.loadVars()
+	if err = c.loadVars(); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
+	if err = c.loadData(); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
+	if err = c.loadSchemas(); err != nil {
+		log.Printf("'%s' %v\n", filename, err)
+		return err
+	}
 	c.loadNodes()
 
 	return nil
@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
 
 func TestReplaceNames(t *testing.T) {
 	cases := []struct {
@@ -77,3 +81,247 @@ func TestReplaceNames(t *testing.T) {
 		}
 	}
 }
+
+func TestScopeReplace(t *testing.T) {
+	config := Config{}
+	if err := config.Load("example/vars.yml"); err != nil {
+		t.Fatal("fail config loading")
+	}
+
+	a := NewScope(map[string]int{})
+	b := NewScope(map[string]int{})
+	a.Set("token", "aaa", false)
+	b.Set("token", "bbb", false)
+	a.Set("shared", "ccc", true)
+
+	cases := []struct {
+		name  string
+		scope *Scope
+		path  string
+		want  string
+	}{
+		{"scoped a", a, "/t?v=%(token)%", "/t?v=aaa"},
+		{"scoped b", b, "/t?v=%(token)%", "/t?v=bbb"},
+		{"global from a", a, "/t?v=%(shared)%", "/t?v=ccc"},
+		{"global from b", b, "/t?v=%(shared)%", "/t?v=ccc"},
+		{"const", b, "/t?v=%(c1)%&t=%(token)%", "/t?v=hoge&t=bbb"},
+		{"unknown", b, "/t?v=%(unknown)%", "/t?v=%(unknown)%"},
+	}
+	for _, tt := range cases {
+		if ret := tt.scope.Replace(tt.path); ret != tt.want {
+			t.Fatalf("%s invalid result: want=%s, ret=%s", tt.name, tt.want, ret)
+		}
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	config := Config{}
+	if err := config.Load("example/scenarios.yml"); err != nil {
+		t.Fatal("fail config loading")
+	}
+
+	if n := len(config.allActions()); n != 5 {
+		t.Fatalf("invalid actions: want=5, ret=%d", n)
+	}
+
+	count := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		count[config.pickScenario().Name] += 1
+	}
+	for name, want := range map[string]int{"browse": 7000, "search": 2500, "checkout": 500} {
+		if ret := count[name]; ret < want*8/10 || ret > want*12/10 {
+			t.Fatalf("%s invalid count: want=%d, ret=%d", name, want, ret)
+		}
+	}
+
+	// weight 0 disables a scenario, the weight is 1 when omitted
+	zero := 0.0
+	actions := []map[string]interface{}{{"path": "/"}}
+	config = Config{Scenarios: []Scenario{{Name: "a", Weight: &zero, Actions: actions}, {Name: "b", Actions: actions}}}
+	if err := config.loadScenarios(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if name := config.pickScenario().Name; name != "b" {
+			t.Fatalf("invalid scenario: %s", name)
+		}
+	}
+	config.Scenarios = config.Scenarios[:1]
+	if err := config.loadScenarios(); err == nil {
+		t.Fatal("want error for all weights 0")
+	}
+
+	config = Config{Actions: actions, Scenarios: config.Scenarios}
+	if err := config.loadScenarios(); err == nil {
+		t.Fatal("want error for actions with scenarios")
+	}
+}
+
+func TestScopeFilters(t *testing.T) {
+	sc := NewScope(map[string]int{})
+	sc.Set("q", `a&b "c"`, false)
+	sc.Set("name", "gohakai", false)
+
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"/s?q=%(q|urlencode)%", "/s?q=a%26b+%22c%22"},
+		{`{"q": "%(q|json)%"}`, `{"q": "a&b \"c\""}`},
+		{"%(name|base64)%", "Z29oYWthaQ=="},
+		{"%(name|sha256)%", "d8234cfd87be6569eb4ec7ed2ce4a2c88fc59d2727fd180304809e5ddf331add"},
+		{"%(name|upper)%", "GOHAKAI"},
+		{"%(token:-anonymous)%", "anonymous"},
+		{"%(token:-)%", ""},
+		{"%(name:-anonymous)%", "gohakai"},
+		{"%(token:-guest|upper)%", "GUEST"},
+		{`%(now("unix")|sha256|upper|lower|base64)%`, ""},
+		{"%(name|unknown)%", "%(name|unknown)%"},
+		{"%(token)%", "%(token)%"},
+	}
+	for _, tt := range cases {
+		ret := sc.Replace(tt.input)
+		if tt.want == "" && strings.HasPrefix(tt.input, "%(now") {
+			// 64 hex digits in base64
+			if len(ret) != 88 {
+				t.Fatalf("%s invalid result: %s", tt.input, ret)
+			}
+			continue
+		}
+		if ret != tt.want {
+			t.Fatalf("%s invalid result: want=%s, ret=%s", tt.input, tt.want, ret)
+		}
+	}
+
+	sc.Unresolved = nil
+	sc.Replace("/%(a)%/%(name)%/%(b:-x)%/%(a)%")
+	if err := (&unresolvedError{names: sc.Unresolved}); err.Error() != "unresolved %(a)%" {
+		t.Fatalf("invalid unresolved: %v", err)
+	}
+}
+
+func TestCheckFilters(t *testing.T) {
+	cases := []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{Headers: map[string]string{"X-Q": "%(q|urlencode|upper)%"}}, true},
+		{Config{Headers: map[string]string{"X-Q": "%(q|urlencod)%"}}, false},
+		{Config{QueryParams: map[string]string{"q": "%(q:-a|b|json)%"}}, false},
+		{Config{Actions: []map[string]interface{}{{"path": "/%(q|lower)%"}}}, true},
+		{Config{Actions: []map[string]interface{}{{"path": "/%(q|lowr)%"}}}, false},
+		{Config{Actions: []map[string]interface{}{{"path": "/", "content": "%(q|jsn)%"}}}, false},
+		{Config{Actions: []map[string]interface{}{{"path": "/", "headers": map[string]string{"A": "%(q|b64)%"}}}}, false},
+		{Config{Actions: []map[string]interface{}{{"path": "/", "query_params": map[string]string{"a": "%(q|sha1)%"}}}}, false},
+		{Config{Actions: []map[string]interface{}{{"path": "/", "post_params": map[interface{}]interface{}{"a": "%(q|x)%"}}}}, false},
+	}
+	for i, tt := range cases {
+		if err := tt.config.loadScenarios(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.config.checkFilters(); (err == nil) != tt.valid {
+			t.Fatalf("%d invalid result: %v", i, err)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	config := Config{}
+	if err := config.Load("example/data.yml"); err != nil {
+		t.Fatal("fail config loading")
+	}
+
+	want := []string{"alice/pa55 word/admin", "bob/se,cret/user", "carol/qwerty/user", "alice/pa55 word/admin"}
+	vu := &VU{ID: 1}
+	for i, w := range want {
+		sc := NewScope(map[string]int{})
+		_, sc.Rows, _, _ = bindValues(vu)
+		if ret := sc.Replace("%(users.name)%/%(users.password)%/%(users.role)%"); ret != w {
+			t.Fatalf("%d invalid result: want=%s, ret=%s", i, w, ret)
+		}
+		if ret := sc.Replace("%(items.price)%"); ret != "100" && ret != "250" {
+			t.Fatalf("%d invalid result: ret=%s", i, ret)
+		}
+		if ret := sc.Replace("%(users.unknown)%"); ret != "%(users.unknown)%" {
+			t.Fatalf("%d invalid result: ret=%s", i, ret)
+		}
+	}
+
+	// 3 procs of 4 from offset 1
+	shard := shardData(1, 3, 4)["users"]
+	if len(shard.Rows) != 2 || shard.Rows[0][0] != "bob" || shard.Rows[1][0] != "carol" {
+		t.Fatalf("invalid shard: %v", shard.Rows)
+	}
+}
+
+func TestDataJSONLines(t *testing.T) {
+	config := Config{}
+	if err := config.Load("example/jsonl.yml"); err != nil {
+		t.Fatal("fail config loading")
+	}
+
+	set := DATA["orders"]
+	if len(set.Rows) != 3 {
+		t.Fatalf("invalid rows: %d", len(set.Rows))
+	}
+
+	sc := NewScope(map[string]int{})
+	sc.Rows = map[string]int{"orders": 1}
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"%(orders.id)%", "o-2"},
+		{"%(orders.customer.name)%", "bob"},
+		{"%(orders.items[1].qty)%", "5"},
+		{"%(orders.items[*].sku)%", "B-2"},
+		{"%(orders.customer)%", `{"id":11,"name":"bob"}`},
+		{"%(orders.nothing)%", "%(orders.nothing)%"},
+	}
+	for _, tt := range cases {
+		if ret := sc.Replace(tt.input); ret != tt.want {
+			t.Fatalf("%s invalid result: want=%s, ret=%s", tt.input, tt.want, ret)
+		}
+	}
+
+	if ret := set.Content(0); ret != set.Rows[0][0] {
+		t.Fatalf("invalid content: %s", ret)
+	}
+	for _, key := range []string{"post_params", "content"} {
+		c := Config{Actions: []map[string]interface{}{{"path": "/", "content_from": "orders", key: "x"}}}
+		c.loadScenarios()
+		if err := c.loadData(); err == nil {
+			t.Fatalf("content_from with %s is accepted", key)
+		}
+	}
+
+	// shipped to a node by the gob file
+	ExecMode = MODE_NODE
+	DATA["orders"] = &DataSet{Format: "jsonl", Rows: [][]string{{`{"id": `}}}
+	err := (&Config{Data: []DataSource{{Name: "orders", File: "orders.jsonl"}}}).loadData()
+	ExecMode = MODE_NORMAL
+	if err == nil {
+		t.Fatal("invalid records are accepted in node mode")
+	}
+
+	users := &DataSet{Format: "csv", Fields: []string{"name", "role"}, Rows: [][]string{{"alice", "admin"}}}
+	if ret := users.Content(0); ret != `{"name":"alice","role":"admin"}` {
+		t.Fatalf("invalid content: %s", ret)
+	}
+}
+
+func TestCompileScan(t *testing.T) {
+	actions := []map[string]interface{}{{"path": "/", "scan": `id="(?P<id>\d+)"`}}
+	if err := compileActions(actions); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := actions[0]["scan"].(*regexp.Regexp); !ok {
+		t.Fatalf("scan is not compiled: %#v", actions[0]["scan"])
+	}
+
+	for _, scan := range []interface{}{"(", 1} {
+		if err := compileActions([]map[string]interface{}{{"path": "/", "scan": scan}}); err == nil {
+			t.Fatalf("%v: want error", scan)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DataSource is a file of records. One record is bound to a scenario and
+// its fields are referenced as %(name.field)%.
+//
+//	data:
+//	    - name: users
+//	      file: users.csv       # .csv or .tsv with a header line
+//	      policy: per_vu        # sequential_wrap by default, see POLICY_*
+//	    - name: orders
+//	      file: orders.jsonl    # an object per line, %(orders.items[0].sku)%
+//
+// The whole record is sent as the body by "content_from: orders" of an
+// action (CSV records as JSON objects). Records are sharded to nodes like
+// exvars.
+type DataSource struct {
+	Name   string `yaml:"name"`
+	File   string `yaml:"file"`
+	Format string `yaml:"format"`
+	Policy string `yaml:"policy"`
+}
+
+// DataSet is the loaded records of a data source. A row of JSON Lines is
+// the line itself.
+type DataSet struct {
+	Format string
+	Policy string
+	Fields []string
+	Rows   [][]string
+	cursor
+
+	docs []interface{} // parsed JSON Lines
+}
+
+// compiled JSONPath of fields in JSON Lines
+var dataPaths sync.Map
+
+var DATA map[string]*DataSet
+
+func dataFormat(ds DataSource) string {
+	if ds.Format != "" {
+		return ds.Format
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(ds.File)), ".")
+}
+
+func loadCSV(filename string, comma rune) (*DataSet, error) {
+	f, err := os.Open(filepath.Join(CONFIG_ROOT, filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = comma
+	if comma == '\t' {
+		r.LazyQuotes = true
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s: no records", filename)
+	}
+
+	fields := records[0]
+	fields[0] = strings.TrimPrefix(fields[0], "\ufeff")
+	return &DataSet{Fields: fields, Rows: records[1:]}, nil
+}
+
+func loadJSONLines(filename string) (*DataSet, error) {
+	f, err := os.Open(filepath.Join(CONFIG_ROOT, filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := &DataSet{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			set.Rows = append(set.Rows, []string{line})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(set.Rows) == 0 {
+		return nil, fmt.Errorf("%s: no records", filename)
+	}
+	return set, nil
+}
+
+// parse the rows of JSON Lines
+func (set *DataSet) parse() error {
+	if set.Format != "jsonl" {
+		return nil
+	}
+	set.docs = make([]interface{}, len(set.Rows))
+	for i, row := range set.Rows {
+		doc, err := ParseJSON([]byte(row[0]))
+		if err != nil {
+			return fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if _, ok := doc.(map[string]interface{}); !ok {
+			return fmt.Errorf("line %d: not an object", i+1)
+		}
+		set.docs[i] = doc
+	}
+	return nil
+}
+
+// the record as a request body
+func (set *DataSet) Content(row int) string {
+	if set.Format == "jsonl" {
+		return set.Rows[row][0]
+	}
+	m := map[string]string{}
+	for i, f := range set.Fields {
+		if i < len(set.Rows[row]) {
+			m[f] = set.Rows[row][i]
+		}
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// load data sources from CONFIG_ROOT. nodes load them from the gob file.
+func (c *Config) loadData() error {
+	for i, ds := range c.Data {
+		if ds.Name == "" || strings.Contains(ds.Name, ".") {
+			return fmt.Errorf("data[%d]: invalid name %q", i, ds.Name)
+		}
+		if _, ok := DATA[ds.Name]; ok && MODE_NORMAL == ExecMode {
+			return fmt.Errorf("data %s: duplicated name", ds.Name)
+		}
+
+		policy, err := parsePolicy(ds.Policy, POLICY_SEQUENTIAL_WRAP)
+		if err != nil {
+			return fmt.Errorf("data %s: %v", ds.Name, err)
+		}
+
+		if MODE_NORMAL != ExecMode {
+			if set, ok := DATA[ds.Name]; ok {
+				if err := set.parse(); err != nil {
+					return fmt.Errorf("data %s: %v", ds.Name, err)
+				}
+			}
+			continue
+		}
+
+		var set *DataSet
+		format := dataFormat(ds)
+		switch format {
+		case "csv":
+			set, err = loadCSV(ds.File, ',')
+		case "tsv":
+			set, err = loadCSV(ds.File, '\t')
+		case "jsonl", "ndjson":
+			format = "jsonl"
+			set, err = loadJSONLines(ds.File)
+		default:
+			err = fmt.Errorf("unknown format %s", format)
+		}
+		if err == nil {
+			set.Format = format
+			set.Policy = policy
+			err = set.parse()
+		}
+		if err != nil {
+			return fmt.Errorf("data %s: %v", ds.Name, err)
+		}
+		DATA[ds.Name] = set
+	}
+
+	for i, action := range c.allActions() {
+		if v, ok := action["content_from"]; ok {
+			if _, ok := DATA[fmt.Sprint(v)]; !ok {
+				return fmt.Errorf("actions[%d]: content_from: unknown data %v", i, v)
+			}
+			for _, key := range []string{"post_params", "content"} {
+				if _, ok := action[key]; ok {
+					return fmt.Errorf("actions[%d]: content_from and %s can not be used together", i, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shard the records for the procs from offset of allProcs, like exvars
+func shardData(offset, procs, allProcs int) map[string]*DataSet {
+	ret := map[string]*DataSet{}
+	for name, set := range DATA {
+		rows := [][]string{}
+		for o := offset; o < offset+procs; o++ {
+			for i := o; i < len(set.Rows); i += allProcs {
+				rows = append(rows, set.Rows[i])
+			}
+		}
+		ret[name] = &DataSet{Format: set.Format, Policy: set.Policy, Fields: set.Fields, Rows: rows}
+	}
+	return ret
+}
+
+// value of "name.field" in the record bound to the scope
+func (sc *Scope) lookupData(name string) (string, bool) {
+	idx := strings.Index(name, ".")
+	if idx < 0 {
+		return "", false
+	}
+	set, ok := DATA[name[:idx]]
+	if !ok {
+		return "", false
+	}
+	row, ok := sc.Rows[name[:idx]]
+	if !ok {
+		return "", false
+	}
+
+	field := name[idx+1:]
+	if set.docs != nil {
+		return lookupJSONField(set.docs[row], field)
+	}
+	for i, f := range set.Fields {
+		if f == field && i < len(set.Rows[row]) {
+			return set.Rows[row][i], true
+		}
+	}
+	return "", false
+}
+
+func lookupJSONField(doc interface{}, field string) (string, bool) {
+	p, ok := dataPaths.Load(field)
+	if !ok {
+		path, err := CompileJSONPath("$." + field)
+		if err != nil {
+			path = nil
+		}
+		p, _ = dataPaths.LoadOrStore(field, path)
+	}
+	path := p.(*JSONPath)
+	if path == nil {
+		return "", false
+	}
+
+	nodes := path.Find(doc)
+	if len(nodes) == 0 {
+		return "", false
+	}
+	return JSONString(nodes[0]), true
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a condition over variables for "if" and "repeat: {while}".
+//
+//	%(status)% == "pending" && %(retry)% < 3
+//	!(%(role)% =~ "^admin") || %(debug)%
+//
+// Operands are %(name)% (empty if unknown, with filters and defaults),
+// quoted strings (which may contain %(name)%), numbers, true and false.
+// Operators are == != < <= > >= =~ !~ ! && || and parentheses.
+// Values are compared as numbers when both are numbers. A value is true
+// unless it is "", "0" or "false".
+type Expr struct {
+	Source string
+	root   exprNode
+}
+
+type exprNode interface {
+	eval(sc *Scope) string
+}
+
+type exprValue string
+
+type exprVar string
+
+type exprString string
+
+type exprNot struct {
+	x exprNode
+}
+
+type exprBinary struct {
+	op   string
+	x, y exprNode
+	re   *regexp.Regexp // for a constant pattern
+}
+
+func (v exprValue) eval(sc *Scope) string {
+	return string(v)
+}
+
+func (v exprVar) eval(sc *Scope) string {
+	s, _ := sc.Resolve(string(v))
+	return s
+}
+
+func (v exprString) eval(sc *Scope) string {
+	return sc.Replace(string(v))
+}
+
+func exprBool(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+func truthy(s string) bool {
+	return s != "" && s != "0" && s != "false"
+}
+
+func (n *exprNot) eval(sc *Scope) string {
+	return exprBool(!truthy(n.x.eval(sc)))
+}
+
+func (n *exprBinary) eval(sc *Scope) string {
+	switch n.op {
+	case "&&":
+		return exprBool(truthy(n.x.eval(sc)) && truthy(n.y.eval(sc)))
+	case "||":
+		return exprBool(truthy(n.x.eval(sc)) || truthy(n.y.eval(sc)))
+	}
+
+	x, y := n.x.eval(sc), n.y.eval(sc)
+	switch n.op {
+	case "=~", "!~":
+		re := n.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(y); err != nil {
+				return "false"
+			}
+		}
+		return exprBool(re.MatchString(x) == (n.op == "=~"))
+	}
+
+	var cmp int
+	fx, errX := strconv.ParseFloat(x, 64)
+	fy, errY := strconv.ParseFloat(y, 64)
+	if errX == nil && errY == nil {
+		switch {
+		case fx < fy:
+			cmp = -1
+		case fx > fy:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(x, y)
+	}
+
+	switch n.op {
+	case "==":
+		return exprBool(cmp == 0)
+	case "!=":
+		return exprBool(cmp != 0)
+	case "<":
+		return exprBool(cmp < 0)
+	case "<=":
+		return exprBool(cmp <= 0)
+	case ">":
+		return exprBool(cmp > 0)
+	default: // ">="
+		return exprBool(cmp >= 0)
+	}
+}
+
+// Eval returns whether the expression holds in the scope
+func (e *Expr) Eval(sc *Scope) bool {
+	return truthy(e.root.eval(sc))
+}
+
+type exprToken struct {
+	kind  byte // 'v'ar, 's'tring, 'n'umber (and true/false), 'o'perator
+	value string
+}
+
+var exprOperators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenizeExpr(s string) (tokens []exprToken, err error) {
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i += 1
+		case strings.HasPrefix(s[i:], "%("):
+			end := strings.Index(s[i:], ")%")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed %%( at %d", i)
+			}
+			tokens = append(tokens, exprToken{'v', s[i+2 : i+end]})
+			i += end + 2
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j += 1
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unclosed string at %d", i)
+			}
+			tokens = append(tokens, exprToken{'s', b.String()})
+			i = j + 1
+		case c == '-' || c == '.' || c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] == '.' || s[j] >= '0' && s[j] <= '9') {
+				j += 1
+			}
+			if _, err := strconv.ParseFloat(s[i:j], 64); err != nil {
+				return nil, fmt.Errorf("invalid number %s", s[i:j])
+			}
+			tokens = append(tokens, exprToken{'n', s[i:j]})
+			i = j
+		case strings.HasPrefix(s[i:], "true") || strings.HasPrefix(s[i:], "false"):
+			word := "true"
+			if c == 'f' {
+				word = "false"
+			}
+			tokens = append(tokens, exprToken{'n', word})
+			i += len(word)
+		default:
+			found := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, exprToken{'o', op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek(ops ...string) string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != 'o' {
+		return ""
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].value == op {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") != "" {
+		p.pos += 1
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: "||", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") != "" {
+		p.pos += 1
+		y, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: "&&", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) not() (exprNode, error) {
+	if p.peek("!") != "" {
+		p.pos += 1
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNot{x: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek("==", "!=", "<=", ">=", "<", ">", "=~", "!~")
+	if op == "" {
+		return x, nil
+	}
+	p.pos += 1
+	y, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &exprBinary{op: op, x: x, y: y}
+	if s, ok := y.(exprString); ok && (op == "=~" || op == "!~") && !re.MatchString(string(s)) {
+		if n.re, err = regexp.Compile(string(s)); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	t := p.tokens[p.pos]
+	p.pos += 1
+	switch t.kind {
+	case 'v':
+		return exprVar(t.value), nil
+	case 's':
+		return exprString(t.value), nil
+	case 'n':
+		return exprValue(t.value), nil
+	}
+	if t.value != "(" {
+		return nil, fmt.Errorf("unexpected %s", t.value)
+	}
+	x, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(")") == "" {
+		return nil, fmt.Errorf("missing )")
+	}
+	p.pos += 1
+	return x, nil
+}
+
+func CompileExpr(s string) (*Expr, error) {
+	tokens, err := tokenizeExpr(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", s, err)
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.or()
+	if err == nil && p.pos < len(tokens) {
+		err = fmt.Errorf("unexpected %s", tokens[p.pos].value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", s, err)
+	}
+	return &Expr{Source: s, root: root}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	sc := NewScope(map[string]int{})
+	sc.Set("status", "pending", false)
+	sc.Set("retry", "2", false)
+	sc.Set("id", "10", false)
+	sc.Set("empty", "", false)
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`%(status)% == "pending"`, true},
+		{`%(status)% != 'pending'`, false},
+		{`%(retry)% < 3`, true},
+		{`%(id)% > 9`, true},
+		{`%(id)% > "9"`, true},
+		{`%(status)% > "a"`, true},
+		{`%(retry)% >= 2 && %(status)% == "done"`, false},
+		{`%(retry)% >= 2 || %(status)% == "done"`, true},
+		{`!(%(status)% == "done")`, true},
+		{`%(status)% =~ "^pend"`, true},
+		{`%(status)% !~ "^pend"`, false},
+		{`%(unknown)% == ""`, true},
+		{`%(unknown)%`, false},
+		{`%(empty)%`, false},
+		{`%(status)%`, true},
+		{`"%(status)%-x" == "pending-x"`, true},
+		{`true && !false`, true},
+		{`0`, false},
+		{`-1 < 0.5`, true},
+		{`1 == 1 && (2 == 3 || 4 == 4)`, true},
+	}
+	for _, tt := range cases {
+		e, err := CompileExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if ret := e.Eval(sc); ret != tt.want {
+			t.Fatalf("%s invalid result: want=%v, ret=%v", tt.expr, tt.want, ret)
+		}
+	}
+
+	for _, s := range []string{``, `%(a)% ==`, `(1 == 1`, `"abc`, `%(a`, `a == 1`, `1 == 1)`, `%(a)% =~ "("`} {
+		if _, err := CompileExpr(s); err == nil {
+			t.Fatalf("%s: want error", s)
+		}
+	}
+}
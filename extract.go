@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Response is a received response given to extractors.
+// The body is decoded and parsed lazily.
+type Response struct {
+	*http.Response
+	Raw  []byte
+	Time time.Duration
+	Jar  http.CookieJar
+
+	body    []byte
+	decoded bool
+	json    interface{}
+	jsonErr error
+	parsed  bool
+	html    *html.Node
+	htmlErr error
+}
+
+// decoded body
+func (r *Response) Body() []byte {
+	if !r.decoded {
+		r.body = decodeBody(r.Raw, r.Header.Get("Content-Encoding"))
+		r.decoded = true
+	}
+	return r.body
+}
+
+func (r *Response) JSON() (interface{}, error) {
+	if !r.parsed {
+		r.json, r.jsonErr = ParseJSON(r.Body())
+		r.parsed = true
+	}
+	return r.json, r.jsonErr
+}
+
+func (r *Response) HTML() (*html.Node, error) {
+	if r.html == nil && r.htmlErr == nil {
+		r.html, r.htmlErr = html.Parse(bytes.NewReader(r.Body()))
+	}
+	return r.html, r.htmlErr
+}
+
+var errNotFound = errors.New("not found")
+
+// Extractor pulls values from a response into a variable of the scope.
+//
+//	extract:
+//	    token: $.data.access_token           # JSONPath
+//	    ids:
+//	        json: $.items[*].id
+//	        required: false
+//	    location:
+//	        header: Location
+//	    sid:
+//	        cookie: SESSIONID                # from the cookie jar
+//	    csrf:
+//	        css: input[name=csrf]            # CSS selector on HTML
+//	        attr: value                      # text content if omitted
+//
+// A missing required value makes the request failed.
+type Extractor struct {
+	Name     string
+	Required bool
+	JSON     *JSONPath
+	Header   string
+	Cookie   string
+	CSS      *Selector
+	Attr     string
+}
+
+func (e *Extractor) Extract(r *Response) ([]string, error) {
+	switch {
+	case e.Header != "":
+		values := r.Header.Values(e.Header)
+		if len(values) == 0 {
+			return nil, errNotFound
+		}
+		return values, nil
+	case e.Cookie != "":
+		return e.extractCookie(r)
+	case e.CSS != nil:
+		return e.extractHTML(r)
+	}
+
+	doc, err := r.JSON()
+	if err != nil {
+		return nil, errors.New("invalid json")
+	}
+
+	values := []string{}
+	for _, n := range e.JSON.Find(doc) {
+		values = append(values, JSONString(n))
+	}
+	if len(values) == 0 {
+		return nil, errNotFound
+	}
+	return values, nil
+}
+
+// the cookie in the scenario's jar (set by this or previous responses)
+func (e *Extractor) extractCookie(r *Response) ([]string, error) {
+	var cookies []*http.Cookie
+	if r.Jar != nil {
+		cookies = r.Jar.Cookies(r.Request.URL)
+	} else {
+		cookies = r.Cookies()
+	}
+	for _, c := range cookies {
+		if c.Name == e.Cookie {
+			return []string{c.Value}, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (e *Extractor) extractHTML(r *Response) ([]string, error) {
+	doc, err := r.HTML()
+	if err != nil {
+		return nil, errors.New("invalid html")
+	}
+
+	values := []string{}
+	for _, n := range e.CSS.Find(doc) {
+		if e.Attr == "" {
+			values = append(values, nodeText(n))
+		} else if v, ok := getAttr(n, e.Attr); ok {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil, errNotFound
+	}
+	return values, nil
+}
+
+func compileExtractor(name string, spec interface{}) (*Extractor, error) {
+	e := &Extractor{Name: name, Required: true}
+
+	var opts map[string]interface{}
+	switch v := spec.(type) {
+	case string:
+		opts = map[string]interface{}{"json": v}
+	default:
+		var err error
+		if opts, err = toStringMap(v); err != nil {
+			return nil, fmt.Errorf("extract %s: %v", name, err)
+		}
+	}
+
+	for k, v := range opts {
+		var err error
+		switch k {
+		case "json":
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("extract %s: json must be a string", name)
+			}
+			e.JSON, err = CompileJSONPath(s)
+		case "header", "cookie", "css", "attr":
+			s, ok := v.(string)
+			if !ok || s == "" {
+				return nil, fmt.Errorf("extract %s: %s must be a string", name, k)
+			}
+			switch k {
+			case "header":
+				e.Header = s
+			case "cookie":
+				e.Cookie = s
+			case "css":
+				e.CSS, err = CompileSelector(s)
+			case "attr":
+				e.Attr = s
+			}
+		case "required":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("extract %s: required must be a bool", name)
+			}
+			e.Required = b
+		default:
+			err = fmt.Errorf("unknown option %s", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %v", name, err)
+		}
+	}
+
+	sources := 0
+	for _, ok := range []bool{e.JSON != nil, e.Header != "", e.Cookie != "", e.CSS != nil} {
+		if ok {
+			sources += 1
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("extract %s: specify one of json, header, cookie or css", name)
+	}
+	if e.Attr != "" && e.CSS == nil {
+		return nil, fmt.Errorf("extract %s: attr is only for css", name)
+	}
+
+	return e, nil
+}
+
+func compileExtractors(spec interface{}) ([]*Extractor, error) {
+	opts, err := toStringMap(spec)
+	if err != nil {
+		return nil, fmt.Errorf("extract: %v", err)
+	}
+
+	names := []string{}
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	extractors := []*Extractor{}
+	for _, name := range names {
+		e, err := compileExtractor(name, opts[name])
+		if err != nil {
+			return nil, err
+		}
+		extractors = append(extractors, e)
+	}
+
+	return extractors, nil
+}
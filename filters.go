@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// placeholder is the content of %(...)%: a name or a generator, an optional
+// default after ":-" used when it is not resolved, and filters after "|".
+//
+//	%(v1|urlencode)%
+//	%(token:-anonymous)%
+//	%(name:-guest|upper|json)%
+//
+// filters:
+//
+//	urlencode   query escape (space is +)
+//	json        escape for a JSON string, without the quotes
+//	base64      standard base64
+//	sha256      hex digest
+//	upper       upper case
+//	lower       lower case
+type placeholder struct {
+	name    string
+	def     *string
+	filters []string
+}
+
+var filterFuncs = map[string]func(string) string{
+	"urlencode": url.QueryEscape,
+	"json": func(s string) string {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.Encode(s)
+		quoted := strings.TrimSuffix(b.String(), "\n")
+		return quoted[1 : len(quoted)-1]
+	},
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"sha256": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// parsed placeholders by the content
+var placeholders sync.Map
+
+// index of sep outside of quotes and parentheses, or -1
+func indexOutside(s, sep string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth += 1
+		case c == ')':
+			depth -= 1
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			return i
+		}
+	}
+	return -1
+}
+
+func parsePlaceholder(s string) *placeholder {
+	if p, ok := placeholders.Load(s); ok {
+		return p.(*placeholder)
+	}
+
+	p := &placeholder{}
+	rest := s
+	if i := indexOutside(rest, "|"); i >= 0 {
+		for _, f := range strings.Split(rest[i+1:], "|") {
+			p.filters = append(p.filters, strings.TrimSpace(f))
+		}
+		rest = rest[:i]
+	}
+	if i := indexOutside(rest, ":-"); i >= 0 {
+		def := rest[i+2:]
+		p.def = &def
+		rest = rest[:i]
+	}
+	p.name = rest
+
+	placeholders.Store(s, p)
+	return p
+}
+
+func applyFilters(v string, filters []string) (string, error) {
+	for _, name := range filters {
+		f, ok := filterFuncs[name]
+		if !ok {
+			return "", fmt.Errorf("unknown filter %s", name)
+		}
+		v = f(v)
+	}
+	return v, nil
+}
+
+// unknown filters of placeholders in s
+func checkFilters(s string) error {
+	for _, m := range re.FindAllStringSubmatch(s, -1) {
+		for _, name := range parsePlaceholder(m[1]).filters {
+			if _, ok := filterFuncs[name]; !ok {
+				return fmt.Errorf("unknown filter %s in %s", name, m[0])
+			}
+		}
+	}
+	return nil
+}
+
+// check the filters of placeholders in the config and actions at loading,
+// rather than leaving them unresolved at each request
+func (c *Config) checkFilters() error {
+	for _, m := range []map[string]string{c.Headers, c.QueryParams} {
+		for _, v := range m {
+			if err := checkFilters(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, action := range c.allActions() {
+		values := []string{}
+		for _, key := range []string{"path", "content"} {
+			if v, ok := action[key]; ok {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+		for _, key := range []string{"headers", "query_params"} {
+			m, _ := action[key].(map[string]string)
+			for _, v := range m {
+				values = append(values, v)
+			}
+		}
+		if m, ok := action["post_params"].(map[interface{}]interface{}); ok {
+			for _, v := range m {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+
+		for _, v := range values {
+			if err := checkFilters(v); err != nil {
+				return fmt.Errorf("actions[%d]: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// the names of unresolved placeholders, for the failure reason in strict mode
+type unresolvedError struct {
+	names []string
+}
+
+func (e *unresolvedError) Error() string {
+	names := map[string]bool{}
+	for _, name := range e.names {
+		names["%("+name+")%"] = true
+	}
+	ss := []string{}
+	for name := range names {
+		ss = append(ss, name)
+	}
+	sort.Strings(ss)
+	return "unresolved " + strings.Join(ss, ", ")
+}
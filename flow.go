@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+)
+
+const DEFAULT_REPEAT_MAX = 100
+
+// Repeat runs an action, or a group of actions, several times.
+//
+//	repeat: 3
+//	repeat: {count: 3}
+//	repeat: {while: '%(state)% != "done"', max: 10}    # max is 100 by default
+//
+// while is evaluated before each time.
+type Repeat struct {
+	Count int
+	While *Expr
+}
+
+func (r *Repeat) next(n int, sc *Scope) bool {
+	if n >= r.Count {
+		return false
+	}
+	return r.While == nil || r.While.Eval(sc)
+}
+
+func compileRepeat(spec interface{}) (*Repeat, error) {
+	if n, ok := spec.(int); ok {
+		if n < 0 {
+			return nil, fmt.Errorf("repeat: invalid count %d", n)
+		}
+		return &Repeat{Count: n}, nil
+	}
+
+	opts, err := toStringMap(spec)
+	if err != nil {
+		return nil, fmt.Errorf("repeat %v", err)
+	}
+	r := &Repeat{Count: -1}
+	for k, v := range opts {
+		switch k {
+		case "count", "max":
+			n, ok := v.(int)
+			if !ok || n < 0 {
+				return nil, fmt.Errorf("repeat: invalid %s %v", k, v)
+			}
+			r.Count = n
+		case "while":
+			if r.While, err = CompileExpr(fmt.Sprint(v)); err != nil {
+				return nil, fmt.Errorf("repeat: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("repeat: unknown option %s", k)
+		}
+	}
+	if r.Count < 0 {
+		if r.While == nil {
+			return nil, fmt.Errorf("repeat: count or while is required")
+		}
+		r.Count = DEFAULT_REPEAT_MAX
+	}
+	return r, nil
+}
+
+// flow runs the actions of a scenario by "if", "chance", "repeat" and
+// groups of "actions".
+type flow struct {
+	atk    *Attacker
+	stop   context.Context
+	config *Config
+	// think time of the last request, waited before the next one
+	think *ThinkTime
+}
+
+func (f *flow) enabled(action map[string]interface{}) bool {
+	if chance, ok := action["chance"].(float64); ok && rand.Float64() >= chance {
+		return false
+	}
+	if cond, ok := action["if"].(*Expr); ok && !cond.Eval(f.atk.Scope) {
+		return false
+	}
+	return true
+}
+
+// run the actions in order. return false when stop is done.
+func (f *flow) run(actions []map[string]interface{}) bool {
+	for _, action := range actions {
+		if f.stop.Err() != nil {
+			return false
+		}
+		if !f.enabled(action) {
+			continue
+		}
+
+		r, ok := action["repeat"].(*Repeat)
+		if !ok {
+			r = &Repeat{Count: 1}
+		}
+		for n := 0; r.next(n, f.atk.Scope); n++ {
+			if !f.step(action) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *flow) step(action map[string]interface{}) bool {
+	if group, ok := action["actions"].([]map[string]interface{}); ok {
+		return f.run(group)
+	}
+
+	// think time between requests, not measured as response time
+	if f.think != nil && !sleep(f.stop, f.think.Next()) {
+		return false
+	}
+	f.atk.Action = action
+	if p, ok := action["poll"].(*Poll); ok {
+		if !f.poll(p) {
+			return false
+		}
+	} else {
+		f.atk.Attack()
+	}
+
+	think, ok := action["think_time"].(*ThinkTime)
+	if !ok {
+		think = f.config.thinkTime
+	}
+	f.think = think
+	return f.stop.Err() == nil
+}
+
+// compile "if", "chance", "poll", "repeat" and the group of "actions"
+func compileFlow(action map[string]interface{}) error {
+	if v, ok := action["actions"]; ok {
+		if _, ok := action["path"]; ok {
+			return fmt.Errorf("path and actions can not be used together")
+		}
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("actions must be a list")
+		}
+		group := []map[string]interface{}{}
+		for _, a := range list {
+			m, err := toStringMap(a)
+			if err != nil {
+				return fmt.Errorf("actions %v", err)
+			}
+			group = append(group, m)
+		}
+		if err := compileActions(group); err != nil {
+			return err
+		}
+		action["actions"] = group
+	} else if _, ok := action["path"].(string); !ok {
+		return fmt.Errorf("path is required")
+	}
+
+	if v, ok := action["if"]; ok {
+		cond, err := CompileExpr(fmt.Sprint(v))
+		if err != nil {
+			return fmt.Errorf("if: %v", err)
+		}
+		action["if"] = cond
+	}
+
+	if v, ok := action["chance"]; ok {
+		var chance float64
+		switch n := v.(type) {
+		case int:
+			chance = float64(n)
+		case float64:
+			chance = n
+		default:
+			return fmt.Errorf("chance must be a number")
+		}
+		if chance < 0 || chance > 1 {
+			return fmt.Errorf("chance must be between 0 and 1")
+		}
+		action["chance"] = chance
+	}
+
+	if v, ok := action["poll"]; ok {
+		if _, ok := action["actions"]; ok {
+			return fmt.Errorf("poll can not be used for actions")
+		}
+		p, err := compilePoll(v)
+		if err != nil {
+			return err
+		}
+		action["poll"] = p
+	}
+
+	if v, ok := action["repeat"]; ok {
+		r, err := compileRepeat(v)
+		if err != nil {
+			return err
+		}
+		action["repeat"] = r
+	}
+
+	return nil
+}
+
+// actions in groups are included
+func flattenActions(actions []map[string]interface{}) (ret []map[string]interface{}) {
+	for _, action := range actions {
+		ret = append(ret, action)
+		if group, ok := action["actions"].([]map[string]interface{}); ok {
+			ret = append(ret, flattenActions(group)...)
+		}
+	}
+	return ret
+}
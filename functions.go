@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// generators usable in %(...)%:
+//
+//	uuid()              random UUID v4
+//	randint(1, 1000)    random integer between them (inclusive)
+//	randstr(16)         random alphanumeric string
+//	now("unix_ms")      current time: unix, unix_ms, unix_us, unix_ns,
+//	                    rfc3339 or a Go time layout. unix by default.
+//	seq("order")        counter from 1 by name, unique in the node
+//	vu_id()             id of the virtual user, from 1
+//	iteration()         number of scenarios run by the VU before, from 0
+type funcCall struct {
+	name string
+	args []string
+}
+
+var reFuncCall = regexp.MustCompile(`^\s*([a-z_]+)\((.*)\)\s*$`)
+
+// parsed calls by the placeholder
+var funcCalls sync.Map
+
+var sequences = map[string]int64{}
+var seqMutex sync.Mutex
+
+const randstrLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func parseCall(s string) (*funcCall, bool) {
+	if !strings.Contains(s, "(") {
+		return nil, false
+	}
+	if c, ok := funcCalls.Load(s); ok {
+		return c.(*funcCall), c.(*funcCall) != nil
+	}
+
+	var call *funcCall
+	if m := reFuncCall.FindStringSubmatch(s); m != nil {
+		if args, err := splitArgs(m[2]); err == nil {
+			call = &funcCall{name: m[1], args: args}
+		}
+	}
+	funcCalls.Store(s, call)
+	return call, call != nil
+}
+
+// split by "," outside of quotes, and unquote
+func splitArgs(s string) (args []string, err error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var quote byte
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				b.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			quoted = true
+		case c == ',':
+			args = append(args, argValue(b.String(), quoted))
+			b.Reset()
+			quoted = false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote")
+	}
+	return append(args, argValue(b.String(), quoted)), nil
+}
+
+func argValue(s string, quoted bool) string {
+	if quoted {
+		return s
+	}
+	return strings.TrimSpace(s)
+}
+
+func intArgs(args []string, n int) ([]int64, bool) {
+	if len(args) != n {
+		return nil, false
+	}
+	ret := []int64{}
+	for _, a := range args {
+		i, err := strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		ret = append(ret, i)
+	}
+	return ret, true
+}
+
+func uuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func formatNow(format string) string {
+	now := time.Now()
+	switch format {
+	case "", "unix":
+		return strconv.FormatInt(now.Unix(), 10)
+	case "unix_ms":
+		return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	case "unix_us":
+		return strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10)
+	case "unix_ns":
+		return strconv.FormatInt(now.UnixNano(), 10)
+	case "rfc3339":
+		return now.Format(time.RFC3339)
+	}
+	return now.Format(format)
+}
+
+func nextSeq(name string) int64 {
+	seqMutex.Lock()
+	defer seqMutex.Unlock()
+	sequences[name] += 1
+	return sequences[name]
+}
+
+// call a generator. unknown functions and invalid arguments are not resolved.
+func (sc *Scope) call(c *funcCall) (string, bool) {
+	switch c.name {
+	case "uuid":
+		return uuid(), len(c.args) == 0
+	case "randint":
+		n, ok := intArgs(c.args, 2)
+		if !ok || n[0] > n[1] {
+			return "", false
+		}
+		// the span overflows int64 for wide ranges
+		span := n[1] - n[0] + 1
+		if span <= 0 {
+			return "", false
+		}
+		return strconv.FormatInt(n[0]+mrand.Int63n(span), 10), true
+	case "randstr":
+		n, ok := intArgs(c.args, 1)
+		if !ok || n[0] < 0 {
+			return "", false
+		}
+		b := make([]byte, n[0])
+		for i := range b {
+			b[i] = randstrLetters[mrand.Intn(len(randstrLetters))]
+		}
+		return string(b), true
+	case "now":
+		if len(c.args) > 1 {
+			return "", false
+		}
+		format := ""
+		if len(c.args) == 1 {
+			format = c.args[0]
+		}
+		return formatNow(format), true
+	case "seq":
+		if len(c.args) != 1 {
+			return "", false
+		}
+		return strconv.FormatInt(nextSeq(c.args[0]), 10), true
+	case "vu_id":
+		if sc.VU == nil || len(c.args) != 0 {
+			return "", false
+		}
+		return strconv.Itoa(sc.VU.ID), true
+	case "iteration":
+		return strconv.Itoa(sc.Iteration), len(c.args) == 0
+	}
+	return "", false
+}
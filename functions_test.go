@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestGenerators(t *testing.T) {
+	sc := NewScope(map[string]int{})
+	sc.VU = &VU{ID: 3}
+	sc.Iteration = 5
+
+	cases := []struct {
+		input string
+		want  string // regexp
+	}{
+		{"%(uuid())%", `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{"%(randint(1, 3))%", `^[123]$`},
+		{"%(randstr(16))%", `^[a-zA-Z0-9]{16}$`},
+		{`%(now("unix_ms"))%`, `^\d{13}$`},
+		{"%(now())%", `^\d{10}$`},
+		{`%(now("2006-01-02"))%`, `^\d{4}-\d{2}-\d{2}$`},
+		{"/vu/%(vu_id())%/%(iteration())%", `^/vu/3/5$`},
+		{"%(unknown())%", `^%\(unknown\(\)\)%$`},
+		{"%(randint(3, 1))%", `^%\(randint\(3, 1\)\)%$`},
+		{"%(randint(a, b))%", `^%\(randint`},
+		{"%(randint(-9223372036854775808, 9223372036854775807))%", `^%\(randint`},
+		{"%(randint(0, 9223372036854775807))%", `^%\(randint`},
+		{"%(randint(-1, 9223372036854775806))%", `^%\(randint`},
+		{"%(randint(1, 9223372036854775807))%", `^\d+$`},
+	}
+	for _, tt := range cases {
+		if ret := sc.Replace(tt.input); !regexp.MustCompile(tt.want).MatchString(ret) {
+			t.Fatalf("%s invalid result: want=%s, ret=%s", tt.input, tt.want, ret)
+		}
+	}
+
+	first, _ := strconv.Atoi(sc.Replace(`%(seq("test"))%`))
+	second, _ := strconv.Atoi(sc.Replace(`%(seq('test'))%`))
+	if second != first+1 {
+		t.Fatalf("seq invalid result: first=%d, second=%d", first, second)
+	}
+	if a, b := sc.Replace("%(uuid())%"), sc.Replace("%(uuid())%"); a == b {
+		t.Fatalf("uuid duplicated: %s", a)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+const (
+	histSubBucketBits  = 7
+	histSubBucketCount = 1 << histSubBucketBits
+	histSubBucketHalf  = histSubBucketCount / 2
+)
+
+// Histogram is a log-linear latency histogram in the spirit of HdrHistogram.
+// Values are recorded in microseconds with a relative error below 1/64
+// (~1.6%). Fields are exported so that it can be sent as a part of the gob
+// result from node mode and merged by the controller.
+type Histogram struct {
+	Counts []uint64
+	Total  uint64
+	Sum    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+var histPercentiles = []float64{50, 90, 95, 99, 99.9}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func histIndex(v uint64) int {
+	if v < histSubBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histSubBucketBits
+	return histSubBucketCount + (shift-1)*histSubBucketHalf + int(v>>uint(shift)) - histSubBucketHalf
+}
+
+// highest value (in microseconds) which falls into the bucket
+func histValue(idx int) uint64 {
+	if idx < histSubBucketCount {
+		return uint64(idx)
+	}
+	k := idx - histSubBucketCount
+	shift := uint(k/histSubBucketHalf + 1)
+	sub := uint64(k%histSubBucketHalf + histSubBucketHalf)
+	return ((sub + 1) << shift) - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	idx := histIndex(uint64(d / time.Microsecond))
+	if idx >= len(h.Counts) {
+		counts := make([]uint64, idx+1)
+		copy(counts, h.Counts)
+		h.Counts = counts
+	}
+	h.Counts[idx] += 1
+
+	if h.Total == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.Total += 1
+	h.Sum += d
+}
+
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil || o.Total == 0 {
+		return
+	}
+	if len(o.Counts) > len(h.Counts) {
+		counts := make([]uint64, len(o.Counts))
+		copy(counts, h.Counts)
+		h.Counts = counts
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+
+	if h.Total == 0 || o.Min < h.Min {
+		h.Min = o.Min
+	}
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+	h.Total += o.Total
+	h.Sum += o.Sum
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Total)
+}
+
+// Percentile returns the value at or below which p percent of the recorded
+// values fall. p is given in percent (e.g. 99.9).
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	rank := uint64(p/100.*float64(h.Total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			v := time.Duration(histValue(i)) * time.Microsecond
+			if v > h.Max {
+				return h.Max
+			}
+			if v < h.Min {
+				return h.Min
+			}
+			return v
+		}
+	}
+
+	return h.Max
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	cases := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 500 * time.Millisecond},
+		{90, 900 * time.Millisecond},
+		{99, 990 * time.Millisecond},
+		{99.9, 999 * time.Millisecond},
+		{100, 1000 * time.Millisecond},
+	}
+	for _, tt := range cases {
+		ret := h.Percentile(tt.p)
+		diff := float64(ret-tt.want) / float64(tt.want)
+		if diff < -0.02 || diff > 0.02 {
+			t.Fatalf("p%v invalid result: want=%v, ret=%v", tt.p, tt.want, ret)
+		}
+	}
+	if h.Max != 1000*time.Millisecond || h.Min != time.Millisecond {
+		t.Fatalf("invalid min/max: min=%v, max=%v", h.Min, h.Max)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a := NewHistogram()
+	b := NewHistogram()
+	for i := 0; i < 100; i++ {
+		a.Record(time.Millisecond)
+		b.Record(time.Second)
+	}
+	a.Merge(b)
+
+	if a.Total != 200 {
+		t.Fatalf("invalid total: want=200, ret=%d", a.Total)
+	}
+	if a.Percentile(50) > 2*time.Millisecond {
+		t.Fatalf("invalid p50: ret=%v", a.Percentile(50))
+	}
+	if a.Percentile(99) < 990*time.Millisecond {
+		t.Fatalf("invalid p99: ret=%v", a.Percentile(99))
+	}
+}
@@ -7,25 +7,30 @@ import (
 
 var SUCCESS uint32
 var FAIL uint32
+var DROPPED uint32
+var INTERRUPTED uint32
 
 func Indicator(fin chan bool, wg *sync.WaitGroup) {
 	var skip int
 	for {
 		select {
 		case ret := <-ok:
+			if ret {
+				SUCCESS += 1
+			} else {
+				FAIL += 1
+			}
 			if MODE_NORMAL != ExecMode {
 				continue
 			}
 
 			skip += 1
 			if ret {
-				SUCCESS += 1
 				if skip >= 100 {
 					fmt.Printf(".")
 					skip = 0
 				}
 			} else {
-				FAIL += 1
 				fmt.Printf("x")
 			}
 		case <-fin:
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a subset of JSONPath:
+// $, .name, ['name'], [n], [-n], [*], .*, ..name
+type JSONPath struct {
+	Expr  string
+	steps []jsonPathStep
+}
+
+type jsonPathStep struct {
+	key       string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	p := &JSONPath{Expr: expr}
+	s := strings.TrimSpace(expr)
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("jsonpath must start with $: %s", expr)
+	}
+	s = s[1:]
+
+	for len(s) > 0 {
+		var st jsonPathStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			st.recursive = true
+			s = s[2:]
+			n := jsonPathNameLen(s)
+			if strings.HasPrefix(s, "*") {
+				st.wildcard = true
+				n = 1
+			} else if n == 0 {
+				return nil, fmt.Errorf("invalid jsonpath: %s", expr)
+			}
+			st.key = s[:n]
+			s = s[n:]
+		case strings.HasPrefix(s, ".*"):
+			st.wildcard = true
+			s = s[2:]
+		case strings.HasPrefix(s, "."):
+			s = s[1:]
+			n := jsonPathNameLen(s)
+			if n == 0 {
+				return nil, fmt.Errorf("invalid jsonpath: %s", expr)
+			}
+			st.key = s[:n]
+			s = s[n:]
+		case strings.HasPrefix(s, "["):
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid jsonpath: %s", expr)
+			}
+			in := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			switch {
+			case in == "*":
+				st.wildcard = true
+			case len(in) >= 2 && (in[0] == '\'' || in[0] == '"') && in[len(in)-1] == in[0]:
+				st.key = in[1 : len(in)-1]
+			default:
+				i, err := strconv.Atoi(in)
+				if err != nil {
+					return nil, fmt.Errorf("invalid jsonpath index: %s", expr)
+				}
+				st.index = i
+				st.isIndex = true
+			}
+		default:
+			return nil, fmt.Errorf("invalid jsonpath: %s", expr)
+		}
+		p.steps = append(p.steps, st)
+	}
+
+	return p, nil
+}
+
+func jsonPathNameLen(s string) int {
+	for i, c := range s {
+		if c == '.' || c == '[' {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// ParseJSON decodes the body keeping numbers as they are
+func ParseJSON(body []byte) (v interface{}, err error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err = dec.Decode(&v)
+	return v, err
+}
+
+// Find returns all nodes matched in doc (decoded by ParseJSON)
+func (p *JSONPath) Find(doc interface{}) []interface{} {
+	nodes := []interface{}{doc}
+	for _, st := range p.steps {
+		next := []interface{}{}
+		for _, n := range nodes {
+			if st.recursive {
+				next = append(next, jsonPathDescend(n, st)...)
+			} else {
+				next = append(next, jsonPathChild(n, st)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func jsonPathChild(n interface{}, st jsonPathStep) (ret []interface{}) {
+	switch v := n.(type) {
+	case map[string]interface{}:
+		if st.wildcard {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				ret = append(ret, v[k])
+			}
+		} else if c, ok := v[st.key]; ok && !st.isIndex {
+			ret = append(ret, c)
+		}
+	case []interface{}:
+		if st.wildcard {
+			ret = append(ret, v...)
+		} else if st.isIndex {
+			i := st.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				ret = append(ret, v[i])
+			}
+		}
+	}
+	return ret
+}
+
+// match st against n and all of its descendants
+func jsonPathDescend(n interface{}, st jsonPathStep) (ret []interface{}) {
+	ret = append(ret, jsonPathChild(n, jsonPathStep{key: st.key, wildcard: st.wildcard})...)
+	switch v := n.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ret = append(ret, jsonPathDescend(v[k], st)...)
+		}
+	case []interface{}:
+		for _, c := range v {
+			ret = append(ret, jsonPathDescend(c, st)...)
+		}
+	}
+	return ret
+}
+
+// JSONString converts a node to the string used for %(name)%.
+// strings are not quoted, objects and arrays are encoded as JSON.
+func JSONString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	case nil:
+		return "null"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
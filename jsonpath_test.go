@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	body := []byte(`{
+		"data": {"access_token": "abc", "expires": 3600, "ok": true},
+		"items": [{"id": 1, "tags": ["a"]}, {"id": 2, "tags": ["b", "c"]}],
+		"user.name": "hakai"
+	}`)
+	doc, err := ParseJSON(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		expr string
+		want []string
+	}{
+		{"$.data.access_token", []string{"abc"}},
+		{"$.data.expires", []string{"3600"}},
+		{"$.data.ok", []string{"true"}},
+		{"$['data']['access_token']", []string{"abc"}},
+		{"$.items[*].id", []string{"1", "2"}},
+		{"$.items[0].id", []string{"1"}},
+		{"$.items[-1].id", []string{"2"}},
+		{"$.items[1].tags", []string{`["b","c"]`}},
+		{"$..id", []string{"1", "2"}},
+		{"$['user.name']", []string{"hakai"}},
+		{"$.nothing", nil},
+		{"$.items[5]", nil},
+	}
+	for _, tt := range cases {
+		p, err := CompileJSONPath(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		var ret []string
+		for _, n := range p.Find(doc) {
+			ret = append(ret, JSONString(n))
+		}
+		if !reflect.DeepEqual(ret, tt.want) {
+			t.Fatalf("%s invalid result: want=%v, ret=%v", tt.expr, tt.want, ret)
+		}
+	}
+
+	for _, expr := range []string{"data.token", "$.", "$[x]", "$.a[1"} {
+		if _, err := CompileJSONPath(expr); err == nil {
+			t.Fatalf("%s: want error", expr)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -9,7 +10,10 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -23,6 +27,10 @@ const (
 	MODE_NORMAL        = "default"
 	MODE_NODE          = "node"
 	MODE_NODE_LOCAL    = "node-local"
+	RATE_UNIT_SCENARIO = "scenario"
+	RATE_UNIT_REQUEST  = "request"
+	// exit status when the config can not be loaded or an option is invalid
+	EXIT_CONFIG_ERROR = 2
 )
 
 var client http.Client
@@ -32,54 +40,93 @@ var ExecMode string = MODE_NORMAL
 var GitCommit string
 var PathCount map[string]uint32
 var PathTime map[string]time.Duration
+var PathHist map[string]*Histogram
+var PathPhase map[string]*PhaseStats
+var PathFail map[string]uint32
+var PathRequests map[string]uint32
+var StatusCount map[int]uint32
+var FailCount map[string]uint32
+var TimeSeries map[int64]*TimeBucket
+var StageHist map[int]*Histogram
 var ok chan bool
 var verbose bool
+var ACTIVE int32
 var m sync.Mutex
 
 type Worker struct {
-	Client      http.Client
-	Config      *Config
-	ExVarOffset map[string]int
+	Ctx    context.Context
+	Stop   context.Context
+	Cancel context.CancelFunc
+	Client http.Client
+	Config *Config
 }
 
-func hakai(c http.Client, config *Config, offset map[string]int) {
+// ctx cancels in-flight requests. stop is done when no more actions should
+// be started, and cancel makes it done when values of exvars or data ran
+// out.
+func hakai(ctx, stop context.Context, cancel context.CancelFunc, c http.Client, config *Config, vu *VU) {
+	atomic.AddInt32(&ACTIVE, 1)
+	defer atomic.AddInt32(&ACTIVE, -1)
+
 	u, err := url.Parse(config.Domain)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	queryParams := map[string]string{}
-	for k, v := range config.QueryParams {
-		vv := ReplaceNames(v, offset)
-		queryParams[k] = vv
+	// a scenario started just before the stop takes no values
+	if stop.Err() != nil {
+		return
 	}
-
-	headers := map[string]string{}
-	for k, v := range config.Headers {
-		headers[k] = ReplaceNames(v, offset)
+	offset, rows, name, ok := bindValues(vu)
+	if !ok {
+		if stop.Err() == nil {
+			log.Printf("%s: no values left, stopping\n", name)
+		}
+		cancel()
+		return
 	}
+	scope := NewScope(offset)
+	scope.VU = vu
+	scope.Rows = rows
+	scope.Iteration = vu.Iteration
+	vu.Iteration += 1
+	// vars and generators take a value per scenario
+	queryParams := scope.ReplaceKnownMap(config.QueryParams)
+	headers := scope.ReplaceKnownMap(config.Headers)
 
 	cookieJar, _ := cookiejar.New(nil)
 	c.Jar = cookieJar
 	attacker := Attacker{
+		Ctx:         ctx,
 		Client:      &c,
 		Url:         u,
 		Gzip:        config.Gzip,
 		UserAgent:   config.UserAgent,
 		QueryParams: &queryParams,
 		Headers:     &headers,
-		ExVarOffset: offset,
+		Scope:       scope,
+		PathRules:   config.PathRules,
+		Strict:      config.Strict,
 	}
-	for _, action := range config.Actions {
-		attacker.Action = action
-		attacker.Attack()
+	scenario := config.pickScenario()
+	start := time.Now()
+	f := &flow{atk: &attacker, stop: stop, config: config}
+	if !f.run(scenario.Actions) {
+		return
+	}
+
+	recordScenario(scenario.Name, time.Since(start), attacker.Failed)
+
+	if config.pacing > 0 {
+		sleep(stop, config.pacing-time.Since(start))
 	}
 }
 
 func worker(id int, wg *sync.WaitGroup, limiter chan Worker) {
+	vu := &VU{ID: id + 1}
 	for {
 		ret := <-limiter
-		hakai(ret.Client, ret.Config, ret.ExVarOffset)
+		hakai(ret.Ctx, ret.Stop, ret.Cancel, ret.Client, ret.Config, vu)
 		wg.Done()
 	}
 }
@@ -95,7 +142,9 @@ func setupNode(configFile string) {
 	// scp when nodes option
 	for key := range NODES {
 		if NODES[key].Host == "localhost" {
+			wg.Add(1)
 			go func(_n Node, o, p int) {
+				defer wg.Done()
 				dumpVars(GOB_FILE, o, _n.Proc, p)
 			}(NODES[key], i, allProcs)
 		} else {
@@ -142,26 +191,94 @@ func setupNode(configFile string) {
 	fmt.Println("setup node end")
 }
 
-func attackNode(configFile string, c chan string, wg *sync.WaitGroup) {
+func attackNode(ctx context.Context, configFile string, c chan string, wg *sync.WaitGroup) {
 	for key := range NODES {
 		wg.Add(1)
 		if NODES[key].Host == "localhost" {
 			go func(node Node) {
-				if err := node.LocalAttack(configFile, c); err != nil {
+				if err := node.LocalAttack(ctx, configFile, c); err != nil {
 					log.Println("local attack:", err, node)
+					wg.Done()
 				}
 			}(NODES[key])
 		} else {
 			go func(node Node) {
-				if err := node.RemoteAttack(c); err != nil {
+				if err := node.RemoteAttack(ctx, c); err != nil {
 					log.Println("remote attack:", err, node)
+					wg.Done()
 				}
 			}(NODES[key])
 		}
 	}
 }
 
-func localMain(loop, maxScenario, maxRequest, totalDuration int, config *Config, stats *Statistics) {
+// cancel the attack by SIGINT/SIGTERM, the statistics collected so far are
+// printed. Second signal exits immediately (only in the controller, since
+// local nodes may receive the same SIGINT from the terminal).
+func handleSignal(cancel context.CancelFunc) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	<-sig
+	log.Println("interrupted, stopping...")
+	cancel()
+
+	for {
+		<-sig
+		if ExecMode == MODE_NORMAL {
+			clean()
+			os.Exit(1)
+		}
+	}
+}
+
+// open model: start n scenarios (unlimited when n is 0) at config.Rate
+// regardless of the response time. At most maxRequest scenarios are in
+// flight, arrivals beyond that are dropped and counted. In node mode
+// config.Rate is the share of the node.
+func arrival(ctx, stop context.Context, cancel context.CancelFunc, n, maxRequest int, config *Config, wg *sync.WaitGroup) {
+	rate := config.Rate
+	if config.RateUnit == RATE_UNIT_REQUEST && config.meanActions() > 0 {
+		rate /= config.meanActions()
+	}
+
+	vus := make(chan *VU, maxRequest)
+	for id := 0; id < maxRequest; id++ {
+		vus <- &VU{ID: id + 1}
+	}
+
+	start := time.Now()
+	for i := 0; n <= 0 || i < n; i++ {
+		next := start.Add(time.Duration(float64(i) / rate * float64(time.Second)))
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-stop.Done():
+				return
+			}
+		}
+		if stop.Err() != nil {
+			return
+		}
+
+		select {
+		case vu := <-vus:
+			wg.Add(1)
+			go func() {
+				hakai(ctx, stop, cancel, client, config, vu)
+				vus <- vu
+				wg.Done()
+			}()
+		default:
+			atomic.AddUint32(&DROPPED, 1)
+		}
+	}
+}
+
+// run scenarios until all of them are done, totalDuration[s] is elapsed or
+// ctx is canceled. In-flight requests are drained up to config.GracefulStop
+// after the end of the duration, then canceled.
+func localMain(ctx context.Context, loop, maxScenario, maxRequest, totalDuration int, config *Config, stats *Statistics) {
 	var wg sync.WaitGroup
 	var wgIndicator sync.WaitGroup
 	redirectFunc := func(req *http.Request, via []*http.Request) error {
@@ -186,6 +303,10 @@ func localMain(loop, maxScenario, maxRequest, totalDuration int, config *Config,
 		return nil
 	}
 
+	if len(config.Stages) >= 1 {
+		maxRequest = config.maxTarget()
+	}
+
 	if config.HTTPVersion == 2 {
 		client = http.Client{
 			Transport: &http2.Transport{
@@ -209,33 +330,52 @@ func localMain(loop, maxScenario, maxRequest, totalDuration int, config *Config,
 	stats.MaxRequest = maxRequest
 	stats.StartTime = time.Now()
 
-	// exec worker
-	for num := 0; num < maxRequest; num++ {
-		go worker(num, &wg, limiter)
-	}
-
-	// exec indicator & total duration
+	// exec indicator
 	ok = make(chan bool)
 	indicatorFin := make(chan bool)
 	go Indicator(indicatorFin, &wgIndicator)
 	wgIndicator.Add(1)
-	if totalDuration != 0 {
-		go stats.PrintAfterDuration(totalDuration)
+
+	// total duration
+	n := loop * maxScenario
+	stop, cancelStop := context.WithCancel(ctx)
+	if totalDuration > 0 {
+		n = 0
+		stop, cancelStop = context.WithTimeout(ctx, time.Duration(totalDuration)*time.Second)
 	}
+	defer cancelStop()
+	reqCtx, cancelReq := context.WithCancel(ctx)
+	defer cancelReq()
+	go func() {
+		<-stop.Done()
+		select {
+		case <-time.After(config.gracefulStop):
+		case <-reqCtx.Done():
+		}
+		cancelReq()
+	}()
 
 	// attack
-	for i := 0; i < loop*maxScenario; i++ {
-		wg.Add(1)
-		offset := map[string]int{}
-		for k := range EXVARS {
-			offset[k] = EXVARS[k].Offset
-			EXVARS[k].Offset += 1
-			if EXVARS[k].Offset >= len(EXVARS[k].Value) {
-				EXVARS[k].Offset = 0
+	if len(config.Stages) >= 1 {
+		stageMain(reqCtx, stop, cancelStop, config)
+	} else if config.Rate > 0 {
+		arrival(reqCtx, stop, cancelStop, n, maxRequest, config, &wg)
+	} else {
+		// exec worker
+		for num := 0; num < maxRequest; num++ {
+			go worker(num, &wg, limiter)
+		}
+	attack:
+		for i := 0; n <= 0 || i < n; i++ {
+			wg.Add(1)
+			w := Worker{Ctx: reqCtx, Stop: stop, Cancel: cancelStop, Client: client, Config: config}
+			select {
+			case limiter <- w:
+			case <-stop.Done():
+				wg.Done()
+				break attack
 			}
 		}
-		w := Worker{Client: client, Config: config, ExVarOffset: offset}
-		limiter <- w
 	}
 
 	// wait all request & response
@@ -244,6 +384,24 @@ func localMain(loop, maxScenario, maxRequest, totalDuration int, config *Config,
 	wgIndicator.Wait()
 }
 
+func initStats() {
+	PathCount = map[string]uint32{}
+	PathTime = map[string]time.Duration{}
+	PathHist = map[string]*Histogram{}
+	PathPhase = map[string]*PhaseStats{}
+	PathFail = map[string]uint32{}
+	PathRequests = map[string]uint32{}
+	StatusCount = map[int]uint32{}
+	FailCount = map[string]uint32{}
+	TimeSeries = map[int64]*TimeBucket{}
+	StageHist = map[int]*Histogram{}
+	ScenarioCount = map[string]uint32{}
+	ScenarioFail = map[string]uint32{}
+	ScenarioHist = map[string]*Histogram{}
+	PollHist = map[string]*Histogram{}
+	PollTimeout = map[string]uint32{}
+}
+
 func clean() {
 	if _, err := os.Stat(GOB_FILE); err == nil {
 		os.Remove(GOB_FILE)
@@ -276,13 +434,18 @@ func main() {
 	config := Config{}
 	statistics := Statistics{}
 	statistics.Config = &config
-	var maxScenario, maxRequest, loop, totalDuration int
+	var maxScenario, maxRequest, loop, totalDuration, procs int
+	var rate float64
+	var output string
 
 	// command line option
 	flag.IntVar(&maxScenario, "s", 1, "max scenario")
 	flag.IntVar(&maxRequest, "c", 0, "max concurrency requests")
 	flag.IntVar(&loop, "n", 1, "scenario exec N-loop")
-	flag.IntVar(&totalDuration, "d", 0, "total duration")
+	flag.IntVar(&totalDuration, "d", 0, "total duration[s]. keep running scenarios until the deadline (-n is ignored)")
+	flag.Float64Var(&rate, "rate", 0, "arrival rate of scenarios (or requests, see rate_unit) per second. 0 is closed-loop.\nruns -n * -s scenarios or until -d with at most -c in flight, so set -d (or -n) and -c")
+	flag.IntVar(&procs, "f", 1, "number of processes on the node (used by node mode)")
+	flag.StringVar(&output, "o", "", "write the result to the file (.json, .csv, or .xml for JUnit)")
 	flag.BoolVar(&verbose, "verbose", false, "verbose mode")
 
 	flag.Parse()
@@ -292,16 +455,34 @@ func main() {
 	}
 	configFile := args[0]
 
+	if output != "" {
+		if err := checkOutput(output); err != nil {
+			log.Println(err)
+			os.Exit(EXIT_CONFIG_ERROR)
+		}
+	}
+
+	// errors are logged by Load
 	if err := config.Load(configFile); err != nil {
-		usage()
+		os.Exit(EXIT_CONFIG_ERROR)
 	}
 
 	if maxRequest == 0 {
 		maxRequest = maxScenario
 	}
+	if ExecMode != MODE_NORMAL {
+		SHARE.Procs = procs
+	}
+	if rate > 0 {
+		config.Rate = rate
+	}
+	config.share(SHARE)
 
-	PathCount = map[string]uint32{}
-	PathTime = map[string]time.Duration{}
+	initStats()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignal(cancel)
 
 	if len(config.Nodes) >= 1 && ExecMode == MODE_NORMAL {
 		statChan := make(chan string)
@@ -310,15 +491,28 @@ func main() {
 		setupNode(configFile)
 		go statistics.Collector(statChan, &statWg)
 
-		attackNode(configFile, statChan, &statWg)
+		attackNode(ctx, configFile, statChan, &statWg)
 		statWg.Wait()
 	} else {
-		localMain(loop, maxScenario, maxRequest, totalDuration, &config, &statistics)
+		localMain(ctx, loop, maxScenario, maxRequest, totalDuration, &config, &statistics)
 		finishTime := time.Now()
 		statistics.Delta = finishTime.Sub(statistics.StartTime)
 	}
 
 	statistics.Print()
+	passed := true
+	if ExecMode == MODE_NORMAL {
+		if output != "" {
+			if err := statistics.WriteOutput(output); err != nil {
+				log.Println("write output error:", err)
+			}
+		}
+		passed = statistics.ThresholdsPassed()
+	}
 
 	clean()
+
+	if !passed {
+		os.Exit(EXIT_THRESHOLD_FAILED)
+	}
 }
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Report is the machine-readable result written by -o.
+// Times are in milliseconds unless noted.
+type Report struct {
+	Version     string            `json:"version"`
+	GitCommit   string            `json:"git_commit"`
+	ConfigFile  string            `json:"config_file"`
+	Domain      string            `json:"domain"`
+	Options     map[string]string `json:"options"`
+	Nodes       int               `json:"nodes"`
+	StartTime   time.Time         `json:"start_time"`
+	Duration    float64           `json:"duration_sec"`
+	Concurrency int               `json:"concurrency"`
+	Requests    uint32            `json:"requests"`
+	Success     uint32            `json:"success"`
+	Failed      uint32            `json:"failed"`
+	Dropped     uint32            `json:"dropped"`
+	Interrupted uint32            `json:"interrupted"`
+	RPS         float64           `json:"rps"`
+	ErrorRate   float64           `json:"error_rate"`
+	Latency     LatencyReport     `json:"latency"`
+	StatusCodes map[string]uint32 `json:"status_codes"`
+	Errors      map[string]uint32 `json:"errors"`
+	Actions     []ActionReport    `json:"actions"`
+	Stages      []StageReport     `json:"stages,omitempty"`
+	Scenarios   []ScenarioReport  `json:"scenarios,omitempty"`
+	Polls       []PollReport      `json:"polls,omitempty"`
+	Thresholds  []ThresholdResult `json:"thresholds,omitempty"`
+}
+
+type LatencyReport struct {
+	Count       uint64             `json:"count"`
+	Avg         float64            `json:"avg"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+type ActionReport struct {
+	Name     string        `json:"name"`
+	Requests uint32        `json:"requests"`
+	Failed   uint32        `json:"failed"`
+	Latency  LatencyReport `json:"latency"`
+}
+
+type StageReport struct {
+	Name     string        `json:"name"`
+	Start    float64       `json:"start_sec"`
+	Duration float64       `json:"duration_sec"`
+	Target   int           `json:"target"`
+	Requests uint32        `json:"requests"`
+	Failed   uint32        `json:"failed"`
+	Latency  LatencyReport `json:"latency"`
+}
+
+// Duration is of the whole iterations including think time
+type ScenarioReport struct {
+	Name       string        `json:"name"`
+	Weight     float64       `json:"weight"`
+	Iterations uint32        `json:"iterations"`
+	Failed     uint32        `json:"failed"`
+	Duration   LatencyReport `json:"duration"`
+}
+
+// Duration is the time to completion of polling actions
+type PollReport struct {
+	Name      string        `json:"name"`
+	Completed uint64        `json:"completed"`
+	Timeout   uint32        `json:"timeout"`
+	Duration  LatencyReport `json:"duration"`
+}
+
+func newLatencyReport(h *Histogram) LatencyReport {
+	l := LatencyReport{
+		Count:       h.Total,
+		Avg:         msec(h.Mean()),
+		Min:         msec(h.Min),
+		Max:         msec(h.Max),
+		Percentiles: map[string]float64{},
+	}
+	for _, p := range histPercentiles {
+		l.Percentiles[fmt.Sprintf("p%v", p)] = msec(h.Percentile(p))
+	}
+	return l
+}
+
+// Report of the finished attack, built once
+func (s *Statistics) Report() *Report {
+	if s.report == nil {
+		s.report = s.buildReport()
+	}
+	return s.report
+}
+
+func (s *Statistics) buildReport() *Report {
+	nreq := SUCCESS + FAIL
+	r := &Report{
+		Version:     Version,
+		GitCommit:   GitCommit,
+		ConfigFile:  flag.Arg(0),
+		Domain:      s.Config.Domain,
+		Options:     map[string]string{},
+		Nodes:       len(s.Config.Nodes),
+		StartTime:   s.StartTime,
+		Duration:    s.Delta.Seconds(),
+		Concurrency: s.MaxRequest,
+		Requests:    nreq,
+		Success:     SUCCESS,
+		Failed:      FAIL,
+		Dropped:     DROPPED,
+		Interrupted: INTERRUPTED,
+		StatusCodes: map[string]uint32{},
+		Errors:      FailCount,
+		Actions:     []ActionReport{},
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		r.Options[f.Name] = f.Value.String()
+	})
+	if s.Delta > 0 {
+		r.RPS = float64(nreq) / s.Delta.Seconds()
+	}
+	if nreq >= 1 {
+		r.ErrorRate = float64(FAIL) / float64(nreq)
+	}
+	for code, cnt := range StatusCount {
+		r.StatusCodes[strconv.Itoa(code)] = cnt
+	}
+
+	keys := map[string]bool{}
+	for key := range PathHist {
+		keys[key] = true
+	}
+	for key := range PathRequests {
+		keys[key] = true
+	}
+	r.Latency = newLatencyReport(totalHistogram())
+
+	for key := range keys {
+		h, ok := PathHist[key]
+		if !ok {
+			h = NewHistogram()
+		}
+		r.Actions = append(r.Actions, ActionReport{
+			Name:     key,
+			Requests: PathRequests[key],
+			Failed:   PathFail[key],
+			Latency:  newLatencyReport(h),
+		})
+	}
+	sort.Slice(r.Actions, func(i, j int) bool {
+		return r.Actions[i].Name < r.Actions[j].Name
+	})
+
+	requests := map[int32]uint32{}
+	fails := map[int32]uint32{}
+	for _, b := range TimeSeries {
+		requests[b.Stage] += b.Requests
+		fails[b.Stage] += b.Fail
+	}
+	for i, st := range s.Config.Stages {
+		h, ok := StageHist[i]
+		if !ok {
+			h = NewHistogram()
+		}
+		r.Stages = append(r.Stages, StageReport{
+			Name:     st.Name,
+			Start:    stageStart(s.Config.Stages, i).Seconds(),
+			Duration: st.duration.Seconds(),
+			Target:   st.Target,
+			Requests: requests[int32(i)],
+			Failed:   fails[int32(i)],
+			Latency:  newLatencyReport(h),
+		})
+	}
+
+	for _, sc := range s.Config.Scenarios {
+		h, ok := ScenarioHist[sc.Name]
+		if !ok {
+			h = NewHistogram()
+		}
+		r.Scenarios = append(r.Scenarios, ScenarioReport{
+			Name:       sc.Name,
+			Weight:     sc.weight(),
+			Iterations: ScenarioCount[sc.Name],
+			Failed:     ScenarioFail[sc.Name],
+			Duration:   newLatencyReport(h),
+		})
+	}
+
+	for _, key := range pollKeys() {
+		h, ok := PollHist[key]
+		if !ok {
+			h = NewHistogram()
+		}
+		r.Polls = append(r.Polls, PollReport{
+			Name:      key,
+			Completed: h.Total,
+			Timeout:   PollTimeout[key],
+			Duration:  newLatencyReport(h),
+		})
+	}
+
+	r.Thresholds = s.evalThresholds(r)
+
+	return r
+}
+
+// write the result to filename. the format is chosen by the extension:
+// .json, .csv (per action rows) or .xml (JUnit)
+// checked before the attack, not to lose the result at the end
+func checkOutput(filename string) error {
+	switch filepath.Ext(filename) {
+	case ".json", ".csv", ".xml":
+		return nil
+	}
+	return fmt.Errorf("unknown output format: %s", filename)
+}
+
+func (s *Statistics) WriteOutput(filename string) error {
+	if err := checkOutput(filename); err != nil {
+		return err
+	}
+	r := s.Report()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch filepath.Ext(filename) {
+	case ".json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case ".csv":
+		return r.writeCSV(f)
+	default:
+		return r.writeJUnit(f)
+	}
+}
+
+func (r *Report) writeCSV(f *os.File) error {
+	w := csv.NewWriter(f)
+	header := []string{"name", "requests", "failed", "avg_ms", "min_ms"}
+	for _, p := range histPercentiles {
+		header = append(header, fmt.Sprintf("p%v_ms", p))
+	}
+	header = append(header, "max_ms")
+	w.Write(header)
+
+	ms := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 3, 64)
+	}
+	for _, a := range r.Actions {
+		row := []string{
+			a.Name,
+			strconv.FormatUint(uint64(a.Requests), 10),
+			strconv.FormatUint(uint64(a.Failed), 10),
+			ms(a.Latency.Avg),
+			ms(a.Latency.Min),
+		}
+		for _, p := range histPercentiles {
+			row = append(row, ms(a.Latency.Percentiles[fmt.Sprintf("p%v", p)]))
+		}
+		row = append(row, ms(a.Latency.Max))
+		w.Write(row)
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+type JUnitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []JUnitTestSuite `xml:"testsuite"`
+}
+
+type JUnitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []JUnitTestCase `xml:"testcase"`
+}
+
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+}
+
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// every action is a test case which fails when any request of it failed,
+// and so is every threshold.
+func (r *Report) junitSuites() []JUnitTestSuite {
+	suite := JUnitTestSuite{Name: "actions", Time: r.Duration}
+	for _, a := range r.Actions {
+		tc := JUnitTestCase{Name: a.Name, ClassName: "gohakai.actions", Time: a.Latency.Avg / 1000.}
+		if a.Failed >= 1 {
+			tc.Failure = &JUnitFailure{
+				Message: fmt.Sprintf("%d of %d requests failed", a.Failed, a.Requests),
+			}
+			suite.Failures += 1
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Tests = len(suite.Cases)
+
+	if len(r.Thresholds) == 0 {
+		return []JUnitTestSuite{suite}
+	}
+
+	thresholds := JUnitTestSuite{Name: "thresholds", Time: r.Duration}
+	for _, t := range r.Thresholds {
+		tc := JUnitTestCase{Name: t.Expr, ClassName: "gohakai.thresholds"}
+		if !t.Pass {
+			msg := t.Error
+			if msg == "" {
+				msg = fmt.Sprintf("actual: %s", t.display)
+			}
+			tc.Failure = &JUnitFailure{Message: msg}
+			thresholds.Failures += 1
+		}
+		thresholds.Cases = append(thresholds.Cases, tc)
+	}
+	thresholds.Tests = len(thresholds.Cases)
+
+	return []JUnitTestSuite{suite, thresholds}
+}
+
+func (r *Report) writeJUnit(f *os.File) error {
+	f.WriteString(xml.Header)
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(JUnitTestSuites{Suites: r.junitSuites()}); err != nil {
+		return err
+	}
+	_, err := f.WriteString("\n")
+	return err
+}
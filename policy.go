@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// policies to hand out the values of exvars, vars and data to scenarios,
+// by "policy" of each entry:
+//
+//	sequential_wrap    in order, from the first again after the last
+//	                   (the default of exvars and data, "sequential" too)
+//	random             at random with replacement (the default of vars)
+//	random_no_repeat   shuffled, reshuffled after all values are used
+//	unique             in order and at most once, the run stops when
+//	                   no value is left
+//	per_vu             a value pinned to a virtual user for all of its
+//	                   iterations, the run stops when no value is left
+//	                   for a new one
+//
+// Values are sharded to nodes, so unique and per_vu values are not shared
+// across nodes either. vars with a policy other than random are bound to a
+// scenario like exvars (and sharded), instead of picked at each use.
+const (
+	POLICY_SEQUENTIAL_WRAP  = "sequential_wrap"
+	POLICY_RANDOM           = "random"
+	POLICY_RANDOM_NO_REPEAT = "random_no_repeat"
+	POLICY_UNIQUE           = "unique"
+	POLICY_PER_VU           = "per_vu"
+)
+
+func parsePolicy(s, def string) (string, error) {
+	switch s {
+	case "":
+		return def, nil
+	case "sequential":
+		return POLICY_SEQUENTIAL_WRAP, nil
+	case POLICY_SEQUENTIAL_WRAP, POLICY_RANDOM, POLICY_RANDOM_NO_REPEAT, POLICY_UNIQUE, POLICY_PER_VU:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown policy %s", s)
+}
+
+// the policy may run out of values
+func exhaustible(policy string) bool {
+	return policy == POLICY_UNIQUE || policy == POLICY_PER_VU
+}
+
+// cursor hands out the indexes of values by a policy
+type cursor struct {
+	Offset int
+	perm   []int       // shuffled indexes left for random_no_repeat
+	vus    map[int]int // index by VU ID for per_vu
+}
+
+// whether next returns a value, without taking it
+func (c *cursor) available(policy string, n int, vu *VU) bool {
+	if n == 0 {
+		return false
+	}
+	switch policy {
+	case POLICY_PER_VU:
+		if _, ok := c.vus[vu.ID]; ok {
+			return true
+		}
+		return c.Offset < n
+	case POLICY_UNIQUE:
+		return c.Offset < n
+	}
+	return true
+}
+
+// index of the next of n values for the VU, false when no value is left
+func (c *cursor) next(policy string, n int, vu *VU) (int, bool) {
+	if n == 0 {
+		return 0, false
+	}
+
+	switch policy {
+	case POLICY_RANDOM:
+		return rand.Intn(n), true
+	case POLICY_RANDOM_NO_REPEAT:
+		if len(c.perm) == 0 {
+			c.perm = rand.Perm(n)
+		}
+		i := c.perm[0]
+		c.perm = c.perm[1:]
+		return i, true
+	case POLICY_PER_VU:
+		if i, ok := c.vus[vu.ID]; ok {
+			return i, true
+		}
+		if c.Offset >= n {
+			return 0, false
+		}
+		if c.vus == nil {
+			c.vus = map[int]int{}
+		}
+		c.vus[vu.ID] = c.Offset
+		c.Offset += 1
+		return c.Offset - 1, true
+	case POLICY_UNIQUE:
+		if c.Offset >= n {
+			return 0, false
+		}
+		c.Offset += 1
+		return c.Offset - 1, true
+	}
+
+	i := c.Offset % n
+	c.Offset = (i + 1) % n
+	return i, true
+}
+
+// choose a value of each exvar and a record of each data source for a
+// scenario of the VU. false with the name of the source when it ran out,
+// then no value is taken from the others either.
+func bindValues(vu *VU) (offsets, rows map[string]int, name string, ok bool) {
+	VARS_MUTEX.Lock()
+	defer VARS_MUTEX.Unlock()
+
+	for k, ex := range EXVARS {
+		if exhaustible(ex.Policy) && !ex.available(ex.Policy, len(ex.Value), vu) {
+			return nil, nil, k, false
+		}
+	}
+	for k, set := range DATA {
+		if exhaustible(set.Policy) && !set.available(set.Policy, len(set.Rows), vu) {
+			return nil, nil, k, false
+		}
+	}
+
+	offsets = map[string]int{}
+	for k, ex := range EXVARS {
+		if i, ok := ex.next(ex.Policy, len(ex.Value), vu); ok {
+			offsets[k] = i
+		}
+	}
+	rows = map[string]int{}
+	for k, set := range DATA {
+		if i, ok := set.next(set.Policy, len(set.Rows), vu); ok {
+			rows[k] = i
+		}
+	}
+	return offsets, rows, "", true
+}
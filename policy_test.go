@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	vus := []*VU{{ID: 1}, {ID: 2}, {ID: 1}, {ID: 3}, {ID: 2}, {ID: 4}}
+	cases := []struct {
+		policy string
+		want   []int // -1 when no value is left
+	}{
+		{POLICY_SEQUENTIAL_WRAP, []int{0, 1, 2, 0, 1, 2}},
+		{POLICY_UNIQUE, []int{0, 1, 2, -1, -1, -1}},
+		{POLICY_PER_VU, []int{0, 1, 0, 2, 1, -1}},
+	}
+	for _, tt := range cases {
+		c := &cursor{}
+		ret := []int{}
+		for _, vu := range vus {
+			i, ok := c.next(tt.policy, 3, vu)
+			if !ok {
+				i = -1
+			}
+			ret = append(ret, i)
+		}
+		if !reflect.DeepEqual(ret, tt.want) {
+			t.Fatalf("%s invalid result: want=%v, ret=%v", tt.policy, tt.want, ret)
+		}
+	}
+
+	c := &cursor{}
+	for round := 0; round < 3; round++ {
+		ret := []int{}
+		for i := 0; i < 5; i++ {
+			n, _ := c.next(POLICY_RANDOM_NO_REPEAT, 5, vus[0])
+			ret = append(ret, n)
+		}
+		sort.Ints(ret)
+		if !reflect.DeepEqual(ret, []int{0, 1, 2, 3, 4}) {
+			t.Fatalf("random_no_repeat repeated: %v", ret)
+		}
+	}
+
+	if _, ok := c.next(POLICY_SEQUENTIAL_WRAP, 0, vus[0]); ok {
+		t.Fatal("a value of nothing")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"", POLICY_RANDOM},
+		{"sequential", POLICY_SEQUENTIAL_WRAP},
+		{"per_vu", POLICY_PER_VU},
+		{"once", ""},
+	}
+	for _, tt := range cases {
+		ret, err := parsePolicy(tt.input, POLICY_RANDOM)
+		if ret != tt.want || (err != nil) != (tt.want == "") {
+			t.Fatalf("%s invalid result: want=%s, ret=%s, err=%v", tt.input, tt.want, ret, err)
+		}
+	}
+}
+
+func TestBindValues(t *testing.T) {
+	EXVARS = map[string]*ExVer{
+		"a": {Value: []string{"a1", "a2", "a3"}, Policy: POLICY_UNIQUE},
+		"b": {Value: []string{"b1"}, Policy: POLICY_PER_VU},
+	}
+	DATA = map[string]*DataSet{}
+	defer func() { EXVARS, DATA = map[string]*ExVer{}, map[string]*DataSet{} }()
+
+	vu1, vu2 := &VU{ID: 1}, &VU{ID: 2}
+	if offsets, _, _, ok := bindValues(vu1); !ok || offsets["a"] != 0 || offsets["b"] != 0 {
+		t.Fatalf("invalid offsets: %v", offsets)
+	}
+	// b ran out for vu2, a is not taken
+	for i := 0; i < 3; i++ {
+		if _, _, name, ok := bindValues(vu2); ok || name != "b" {
+			t.Fatalf("vu2 is bound: %s", name)
+		}
+	}
+	if offsets, _, _, ok := bindValues(vu1); !ok || offsets["a"] != 1 {
+		t.Fatalf("a is taken by failed bindings: %v", offsets)
+	}
+}
+
+func TestHakaiExhausted(t *testing.T) {
+	EXVARS = map[string]*ExVer{"a": {Value: []string{}, Policy: POLICY_UNIQUE}}
+	DATA = map[string]*DataSet{}
+	defer func() { EXVARS = map[string]*ExVer{} }()
+
+	config := &Config{Domain: "http://localhost:8000", Actions: []map[string]interface{}{{"path": "/"}}}
+	config.loadScenarios()
+
+	// each run is stopped, e.g. runs of a node one after another
+	for i := 0; i < 2; i++ {
+		stop, cancel := context.WithCancel(context.Background())
+		hakai(context.Background(), stop, cancel, http.Client{}, config, &VU{ID: 1})
+		if stop.Err() == nil {
+			t.Fatalf("%d: not stopped", i)
+		}
+	}
+}
+
+func TestHakaiStopped(t *testing.T) {
+	EXVARS = map[string]*ExVer{"a": {Value: []string{"a1", "a2"}, Policy: POLICY_UNIQUE}}
+	DATA = map[string]*DataSet{}
+	defer func() { EXVARS = map[string]*ExVer{} }()
+
+	config := &Config{Domain: "http://localhost:8000", Actions: []map[string]interface{}{{"path": "/"}}}
+	config.loadScenarios()
+
+	stop, cancel := context.WithCancel(context.Background())
+	cancel()
+	vu := &VU{ID: 1}
+	hakai(context.Background(), stop, cancel, http.Client{}, config, vu)
+	if EXVARS["a"].Offset != 0 || vu.Iteration != 0 {
+		t.Fatalf("values are taken after the stop: offset=%d, iteration=%d", EXVARS["a"].Offset, vu.Iteration)
+	}
+}
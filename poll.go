@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	DEFAULT_POLL_INTERVAL = time.Second
+	DEFAULT_POLL_TIMEOUT  = 30 * time.Second
+)
+
+// Poll re-issues the request of an action at interval until the condition
+// holds or timeout elapses.
+//
+//	path: "/jobs/%(job_id)%"
+//	extract:
+//	    state: $.state
+//	poll:
+//	    interval: 500ms                   # 1s by default
+//	    timeout: 10s                      # 30s by default
+//	    until: '%(state)% == "done"'      # the request succeeded if omitted
+//
+// Each request is recorded as usual, and the time to completion of the
+// polling is recorded separately. Timeouts are counted by action, and
+// gated by the "poll_timeout" threshold, e.g. "poll_timeout == 0".
+type Poll struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Until    *Expr
+}
+
+// time to completion and the number of timeouts by action
+var PollHist map[string]*Histogram
+var PollTimeout map[string]uint32
+
+func compilePoll(spec interface{}) (*Poll, error) {
+	opts, err := toStringMap(spec)
+	if err != nil {
+		return nil, fmt.Errorf("poll %v", err)
+	}
+
+	p := &Poll{Interval: DEFAULT_POLL_INTERVAL, Timeout: DEFAULT_POLL_TIMEOUT}
+	for k, v := range opts {
+		switch k {
+		case "interval", "timeout":
+			d, err := time.ParseDuration(fmt.Sprint(v))
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("poll: invalid %s %v", k, v)
+			}
+			if k == "interval" {
+				p.Interval = d
+			} else {
+				p.Timeout = d
+			}
+		case "until":
+			if p.Until, err = CompileExpr(fmt.Sprint(v)); err != nil {
+				return nil, fmt.Errorf("poll: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("poll: unknown option %s", k)
+		}
+	}
+	return p, nil
+}
+
+func recordPoll(key string, d time.Duration, completed bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	if !completed {
+		PollTimeout[key] += 1
+		return
+	}
+	if _, ok := PollHist[key]; !ok {
+		PollHist[key] = NewHistogram()
+	}
+	PollHist[key].Record(d)
+}
+
+// request until the condition holds. return false when stop is done.
+func (f *flow) poll(p *Poll) bool {
+	start := time.Now()
+	for {
+		success := f.atk.Attack()
+		if f.stop.Err() != nil {
+			return false
+		}
+
+		// after the request, as the path is resolved by it
+		key := f.atk.statsKey()
+
+		if p.Until == nil && success || p.Until != nil && p.Until.Eval(f.atk.Scope) {
+			recordPoll(key, time.Since(start), true)
+			return true
+		}
+		if time.Since(start)+p.Interval > p.Timeout {
+			recordPoll(key, time.Since(start), false)
+			f.atk.Failed = true
+			return true
+		}
+
+		if !sleep(f.stop, p.Interval) {
+			return false
+		}
+	}
+}
+
+func pollKeys() []string {
+	keys := map[string]bool{}
+	for key := range PollHist {
+		keys[key] = true
+	}
+	for key := range PollTimeout {
+		keys[key] = true
+	}
+	ret := []string{}
+	for key := range keys {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *Statistics) printPolls() {
+	fmt.Printf("Polling time to completion [ms]:\n")
+	for _, key := range pollKeys() {
+		h, ok := PollHist[key]
+		if !ok {
+			h = NewHistogram()
+		}
+		fmt.Printf("%s completed:%d, timeout:%d\n", key, h.Total, PollTimeout[key])
+		if h.Total >= 1 {
+			fmt.Printf("\t%s\n", formatPercentiles(h))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPollTimeoutThreshold(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"state": "running"}`)
+	}))
+	defer ts.Close()
+
+	filename := filepath.Join(t.TempDir(), "poll.yml")
+	yml := fmt.Sprintf(`
+domain: %s
+thresholds:
+    - error_rate < 1%%
+    - poll_timeout(/jobs) == 0
+actions:
+    - path: /jobs
+      extract:
+          state: $.state
+      poll:
+          interval: 10ms
+          timeout: 30ms
+          until: '%%(state)%% == "done"'
+`, ts.URL)
+	if err := os.WriteFile(filename, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{}
+	if err := config.Load(filename); err != nil {
+		t.Fatal("fail config loading")
+	}
+
+	initStats()
+	SUCCESS, FAIL = 0, 0
+	ok = make(chan bool, 100)
+	hakai(context.Background(), context.Background(), func() {}, http.Client{}, &config, &VU{ID: 1})
+	close(ok)
+	for ret := range ok {
+		if ret {
+			SUCCESS += 1
+		} else {
+			FAIL += 1
+		}
+	}
+
+	// only the requests sent are counted
+	if FAIL != 0 || SUCCESS != PathRequests["/jobs"] || PollTimeout["/jobs"] != 1 {
+		t.Fatalf("invalid result: success=%d, fail=%d, timeout=%d", SUCCESS, FAIL, PollTimeout["/jobs"])
+	}
+	results := (&Statistics{Config: &config}).Report().Thresholds
+	if !results[0].Pass || results[1].Pass || results[1].Actual != 1 {
+		t.Fatalf("invalid thresholds: %+v", results)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,6 +11,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
@@ -23,6 +26,35 @@ type Node struct {
 	Session    *ssh.Session
 }
 
+// Share is the part of the load run by a node. Its processes are Procs
+// (-f) from Offset of AllProcs of all nodes, the same as exvars are sharded.
+type Share struct {
+	Offset   int
+	Procs    int
+	AllProcs int
+}
+
+// the whole load unless in node mode
+var SHARE = Share{Offset: 0, Procs: 1, AllProcs: 1}
+
+// the part of n. the parts of all nodes add up to n.
+func (s Share) Split(n int) int {
+	return n*(s.Offset+s.Procs)/s.AllProcs - n*s.Offset/s.AllProcs
+}
+
+func (s Share) Scale(f float64) float64 {
+	return f * float64(s.Procs) / float64(s.AllProcs)
+}
+
+// run the share of the load of the node: the arrival rate and the targets
+// of stages
+func (c *Config) share(s Share) {
+	c.Rate = s.Scale(c.Rate)
+	for i := range c.Stages {
+		c.Stages[i].Target = s.Split(c.Stages[i].Target)
+	}
+}
+
 func (n *Node) NewSSHSession() (session *ssh.Session, err error) {
 	pkey, err := os.ReadFile(n.SSHKeyFile)
 	if err != nil {
@@ -94,7 +126,7 @@ func (n *Node) Scp(src, dst string) (err error) {
 // return []string{"-f 1", "-s 1", ...}
 // skip -f option
 func rebuildArgs() (ret []string) {
-	args := []string{"s", "c", "n", "d"}
+	args := []string{"s", "c", "n", "d", "rate"}
 	for _, v := range args {
 		if f := flag.Lookup(v); f != nil {
 			ret = append(ret, fmt.Sprintf("-%s", v))
@@ -105,7 +137,9 @@ func rebuildArgs() (ret []string) {
 	return ret
 }
 
-func (n *Node) LocalAttack(configFile string, c chan string) (err error) {
+// interrupt the node process when ctx is canceled, then it prints the
+// statistics collected so far.
+func (n *Node) LocalAttack(ctx context.Context, configFile string, c chan string) (err error) {
 	args := rebuildArgs()
 	args = append(args, "-f")
 	args = append(args, fmt.Sprintf("%d", n.Proc))
@@ -113,26 +147,69 @@ func (n *Node) LocalAttack(configFile string, c chan string) (err error) {
 	cmd := exec.Command(fmt.Sprintf("./%s", HAKAI_BIN_NAME), args...)
 	cmd.Env = []string{fmt.Sprintf("GOHAKAI=%s", MODE_NODE_LOCAL)}
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
 		return err
 	}
 
-	c <- string(out)
+	fin := make(chan bool)
+	defer close(fin)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Signal(os.Interrupt)
+		case <-fin:
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+
+	c <- b.String()
 
 	return err
 }
 
-func (n *Node) RemoteAttack(c chan string) (err error) {
+// the remote shell prints its pid to stderr then execs gohakai, so that it
+// can be interrupted by kill(1) from another session when ctx is canceled.
+func (n *Node) RemoteAttack(ctx context.Context, c chan string) (err error) {
 	rawCmd := strings.Join(rebuildArgs(), " ")
-	command := fmt.Sprintf("GOHAKAI=%s ./%s %s -f %d %s",
+	command := fmt.Sprintf("echo $$ 1>&2; GOHAKAI=%s exec ./%s %s -f %d %s",
 		MODE_NODE, HAKAI_BIN_NAME, rawCmd, n.Proc, REMOTE_CONF)
 
-	n.Session, _ = n.NewSSHSession()
+	n.Session, err = n.NewSSHSession()
+	if err != nil {
+		return err
+	}
 	defer n.Session.Close()
 
 	var b bytes.Buffer
 	n.Session.Stdout = &b
+	stderr, err := n.Session.StderrPipe()
+	if err != nil {
+		return err
+	}
+	pid := make(chan string, 1)
+	go func() {
+		r := bufio.NewReader(stderr)
+		line, _ := r.ReadString('\n')
+		pid <- strings.TrimSpace(line)
+		io.Copy(os.Stderr, r)
+	}()
+
+	fin := make(chan bool)
+	defer close(fin)
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.kill(<-pid)
+		case <-fin:
+		}
+	}()
+
 	if err := n.Session.Run(command); err != nil {
 		log.Println("attack error:", err)
 		return err
@@ -146,3 +223,22 @@ func (n *Node) RemoteAttack(c chan string) (err error) {
 
 	return err
 }
+
+// send SIGINT to the remote gohakai process
+func (n *Node) kill(pid string) {
+	if _, err := strconv.Atoi(pid); err != nil {
+		log.Println("unknown remote pid:", pid, n.Host)
+		return
+	}
+
+	session, err := n.NewSSHSession()
+	if err != nil {
+		log.Println("new ssh session error:", err)
+		return
+	}
+	defer session.Close()
+
+	if err := session.Run(fmt.Sprintf("kill -INT %s", pid)); err != nil {
+		log.Println("kill error:", err, n.Host)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestShare(t *testing.T) {
+	// nodes with 2, 1 and 3 procs
+	shares := []Share{{0, 2, 6}, {2, 1, 6}, {3, 3, 6}}
+	for _, n := range []int{0, 1, 5, 6, 100} {
+		sum := 0
+		for _, s := range shares {
+			sum += s.Split(n)
+		}
+		if sum != n {
+			t.Fatalf("%d invalid sum: %d", n, sum)
+		}
+	}
+
+	if ret := shares[0].Split(12); ret != 4 {
+		t.Fatalf("invalid split: %d", ret)
+	}
+	if ret := shares[2].Scale(10); ret != 5 {
+		t.Fatalf("invalid scale: %v", ret)
+	}
+}
+
+func TestConfigShare(t *testing.T) {
+	config := Config{}
+	if err := config.Load("example/rate.yml"); err != nil {
+		t.Fatal("fail config loading")
+	}
+	rate := config.Rate
+
+	// 1 of 4 procs
+	config.share(Share{Offset: 3, Procs: 1, AllProcs: 4})
+	if config.Rate != rate/4 {
+		t.Fatalf("invalid rate: want=%v, ret=%v", rate/4, config.Rate)
+	}
+}
+
+func TestConfigShareStages(t *testing.T) {
+	config := Config{}
+	if err := config.Load("example/stages.yml"); err != nil {
+		t.Fatal("fail config loading")
+	}
+	targets := []int{}
+	for _, st := range config.Stages {
+		targets = append(targets, st.Target)
+	}
+
+	// nodes with 1 and 2 procs
+	a := Config{Stages: append([]Stage{}, config.Stages...)}
+	b := Config{Stages: append([]Stage{}, config.Stages...)}
+	a.share(Share{Offset: 0, Procs: 1, AllProcs: 3})
+	b.share(Share{Offset: 1, Procs: 2, AllProcs: 3})
+	for i, want := range targets {
+		if a.Stages[i].Target+b.Stages[i].Target != want || a.Stages[i].Target > b.Stages[i].Target {
+			t.Fatalf("stages[%d] invalid targets: %d + %d, want=%d", i, a.Stages[i].Target, b.Stages[i].Target, want)
+		}
+	}
+	if a.maxTarget()+b.maxTarget() != config.maxTarget() {
+		t.Fatalf("invalid max targets: %d + %d", a.maxTarget(), b.maxTarget())
+	}
+
+	config.Stages[0].Target = -1
+	if err := config.loadStages(); err == nil {
+		t.Fatal("negative target is accepted")
+	}
+}
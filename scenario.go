@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const DEFAULT_SCENARIO = "default"
+
+// Scenario is a named list of actions. Each iteration of a virtual user
+// runs one scenario picked by weight.
+//
+//	scenarios:
+//	    - name: browse
+//	      weight: 70
+//	      actions:
+//	          - path: /
+//	    - name: checkout
+//	      weight: 5
+//	      actions:
+//	          - path: /cart
+//
+// The weight is 1 when omitted, and a scenario with weight 0 is not run.
+type Scenario struct {
+	Name    string                   `yaml:"name"`
+	Weight  *float64                 `yaml:"weight"`
+	Actions []map[string]interface{} `yaml:"actions"`
+}
+
+func (sc *Scenario) weight() float64 {
+	if sc.Weight == nil {
+		return 1
+	}
+	return *sc.Weight
+}
+
+// completed iterations, iterations with any failed request and the duration
+// of iterations (including think time) by scenario
+var ScenarioCount map[string]uint32
+var ScenarioFail map[string]uint32
+var ScenarioHist map[string]*Histogram
+
+// validate scenarios. actions without scenarios is the default scenario.
+func (c *Config) loadScenarios() error {
+	if len(c.Scenarios) == 0 {
+		c.scenarios = []Scenario{{Name: DEFAULT_SCENARIO, Actions: c.Actions}}
+		return nil
+	}
+	if len(c.Actions) >= 1 {
+		return fmt.Errorf("actions and scenarios can not be used together")
+	}
+
+	names := map[string]bool{}
+	for i, sc := range c.Scenarios {
+		if sc.Name == "" {
+			return fmt.Errorf("scenarios[%d]: name is required", i)
+		}
+		if names[sc.Name] {
+			return fmt.Errorf("scenarios[%d]: duplicated name %s", i, sc.Name)
+		}
+		names[sc.Name] = true
+		if sc.weight() < 0 {
+			return fmt.Errorf("scenario %s: weight must not be negative", sc.Name)
+		}
+		if len(sc.Actions) == 0 {
+			return fmt.Errorf("scenario %s: no actions", sc.Name)
+		}
+	}
+	c.scenarios = c.Scenarios
+	if c.totalWeight() == 0 {
+		return fmt.Errorf("weights of all scenarios are 0")
+	}
+	return nil
+}
+
+// actions of all scenarios, including the ones in groups
+func (c *Config) allActions() (actions []map[string]interface{}) {
+	for _, sc := range c.scenarios {
+		actions = append(actions, flattenActions(sc.Actions)...)
+	}
+	return actions
+}
+
+func (c *Config) totalWeight() (total float64) {
+	for i := range c.scenarios {
+		total += c.scenarios[i].weight()
+	}
+	return total
+}
+
+// average number of actions in an iteration
+func (c *Config) meanActions() float64 {
+	var n float64
+	for i := range c.scenarios {
+		n += c.scenarios[i].weight() * float64(len(c.scenarios[i].Actions))
+	}
+	return n / c.totalWeight()
+}
+
+func (c *Config) pickScenario() *Scenario {
+	if len(c.scenarios) == 1 {
+		return &c.scenarios[0]
+	}
+	r := rand.Float64() * c.totalWeight()
+	last := 0
+	for i := range c.scenarios {
+		if c.scenarios[i].weight() == 0 {
+			continue
+		}
+		r -= c.scenarios[i].weight()
+		if r < 0 {
+			return &c.scenarios[i]
+		}
+		last = i
+	}
+	return &c.scenarios[last]
+}
+
+func recordScenario(name string, d time.Duration, failed bool) {
+	m.Lock()
+	ScenarioCount[name] += 1
+	if failed {
+		ScenarioFail[name] += 1
+	}
+	if _, ok := ScenarioHist[name]; !ok {
+		ScenarioHist[name] = NewHistogram()
+	}
+	ScenarioHist[name].Record(d)
+	m.Unlock()
+}
+
+func (s *Statistics) printScenarios() {
+	total := s.Config.totalWeight()
+
+	fmt.Printf("Scenarios:\n")
+	for _, sc := range s.Config.Scenarios {
+		fmt.Printf("%s (weight:%.1f%%) iteration count:%d, failed:%d\n",
+			sc.Name, 100*sc.weight()/total, ScenarioCount[sc.Name], ScenarioFail[sc.Name])
+		if h, ok := ScenarioHist[sc.Name]; ok {
+			fmt.Printf("\tduration %s\n", formatPercentiles(h))
+		}
+	}
+}
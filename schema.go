@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema is a subset of JSON Schema for validating response bodies:
+// type, enum, const, properties, required, additionalProperties, items,
+// minItems, maxItems, uniqueItems, minLength, maxLength, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, allOf, anyOf,
+// oneOf, not, and local $ref (#/definitions/..., #/$defs/...).
+// Other keywords like format or patternProperties are rejected at load, so
+// that a schema is not passed silently without them.
+type JSONSchema struct {
+	Name string // file name of the root schema
+
+	always *bool // true or false schema
+
+	types      []string
+	enum       []interface{}
+	constant   interface{}
+	hasConst   bool
+	properties map[string]*JSONSchema
+	required   []string
+	additional *JSONSchema
+	items      *JSONSchema
+	minItems   int
+	maxItems   int
+	unique     bool
+	minLength  int
+	maxLength  int
+	pattern    *regexp.Regexp
+	minimum    *float64
+	maximum    *float64
+	exMinimum  *float64
+	exMaximum  *float64
+	multipleOf float64
+	allOf      []*JSONSchema
+	anyOf      []*JSONSchema
+	oneOf      []*JSONSchema
+	not        *JSONSchema
+	ref        string
+	refs       map[string]*JSONSchema
+}
+
+// keywords without a validation, allowed beside the supported ones
+var schemaAnnotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "definitions": true, "$defs": true,
+}
+
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "properties": true, "required": true,
+	"additionalProperties": true, "items": true, "minItems": true, "maxItems": true,
+	"uniqueItems": true, "minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"multipleOf": true, "allOf": true, "anyOf": true, "oneOf": true, "not": true, "$ref": true,
+}
+
+type schemaCompiler struct {
+	root interface{}
+	refs map[string]*JSONSchema
+}
+
+func CompileJSONSchema(b []byte) (*JSONSchema, error) {
+	root, err := ParseJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	c := &schemaCompiler{root: root, refs: map[string]*JSONSchema{}}
+	return c.compile(root)
+}
+
+func schemaNumber(v interface{}) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func schemaInt(m map[string]interface{}, key string, def int) (int, error) {
+	v, ok := m[key]
+	if !ok {
+		return def, nil
+	}
+	f, ok := schemaNumber(v)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return int(f), nil
+}
+
+func (c *schemaCompiler) compileList(v interface{}, key string) ([]*JSONSchema, error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s must be a non-empty array", key)
+	}
+	ret := []*JSONSchema{}
+	for _, sub := range list {
+		s, err := c.compile(sub)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+func (c *schemaCompiler) compile(v interface{}) (*JSONSchema, error) {
+	if b, ok := v.(bool); ok {
+		return &JSONSchema{always: &b}, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema must be an object or a bool")
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !schemaKeywords[k] && !schemaAnnotations[k] {
+			return nil, fmt.Errorf("unsupported keyword %s", k)
+		}
+	}
+
+	s := &JSONSchema{maxItems: -1, maxLength: -1, refs: c.refs}
+	var err error
+	if v, ok := m["$ref"]; ok {
+		ref, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("$ref must be a string")
+		}
+		if err = c.resolve(ref); err != nil {
+			return nil, err
+		}
+		s.ref = ref
+	}
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, tt := range t {
+			name, ok := tt.(string)
+			if !ok {
+				return nil, fmt.Errorf("type must be a string")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("type must be a string or an array")
+	}
+	for _, t := range s.types {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, fmt.Errorf("unknown type %s", t)
+		}
+	}
+
+	if v, ok := m["enum"]; ok {
+		if s.enum, ok = v.([]interface{}); !ok {
+			return nil, fmt.Errorf("enum must be an array")
+		}
+	}
+	s.constant, s.hasConst = m["const"]
+
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("properties must be an object")
+		}
+		s.properties = map[string]*JSONSchema{}
+		for name, sub := range props {
+			if s.properties[name], err = c.compile(sub); err != nil {
+				return nil, fmt.Errorf("properties.%s: %v", name, err)
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("required must be an array")
+		}
+		for _, name := range list {
+			s.required = append(s.required, fmt.Sprint(name))
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		if s.additional, err = c.compile(v); err != nil {
+			return nil, fmt.Errorf("additionalProperties: %v", err)
+		}
+	}
+
+	if v, ok := m["items"]; ok {
+		if s.items, err = c.compile(v); err != nil {
+			return nil, fmt.Errorf("items: %v", err)
+		}
+	}
+	if s.minItems, err = schemaInt(m, "minItems", 0); err != nil {
+		return nil, err
+	}
+	if s.maxItems, err = schemaInt(m, "maxItems", -1); err != nil {
+		return nil, err
+	}
+	if v, ok := m["uniqueItems"]; ok {
+		if s.unique, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("uniqueItems must be a bool")
+		}
+	}
+
+	if s.minLength, err = schemaInt(m, "minLength", 0); err != nil {
+		return nil, err
+	}
+	if s.maxLength, err = schemaInt(m, "maxLength", -1); err != nil {
+		return nil, err
+	}
+	if v, ok := m["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("pattern must be a string")
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("pattern: %v", err)
+		}
+	}
+
+	for key, dst := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exMinimum,
+		"exclusiveMaximum": &s.exMaximum,
+	} {
+		if v, ok := m[key]; ok {
+			f, ok := schemaNumber(v)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a number", key)
+			}
+			*dst = &f
+		}
+	}
+	if v, ok := m["multipleOf"]; ok {
+		if s.multipleOf, ok = schemaNumber(v); !ok || s.multipleOf <= 0 {
+			return nil, fmt.Errorf("multipleOf must be a positive number")
+		}
+	}
+
+	for key, dst := range map[string]*[]*JSONSchema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		if v, ok := m[key]; ok {
+			if *dst, err = c.compileList(v, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["not"]; ok {
+		if s.not, err = c.compile(v); err != nil {
+			return nil, fmt.Errorf("not: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+// compile the schema pointed by a local ref once. recursive refs are
+// resolved at validation through refs.
+func (c *schemaCompiler) resolve(ref string) error {
+	if _, ok := c.refs[ref]; ok {
+		return nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return fmt.Errorf("only local $ref is supported: %s", ref)
+	}
+
+	node := c.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref[1:], "/"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid $ref: %s", ref)
+		}
+		if node, ok = m[token]; !ok {
+			return fmt.Errorf("invalid $ref: %s", ref)
+		}
+	}
+
+	c.refs[ref] = nil // placeholder for recursion
+	s, err := c.compile(node)
+	if err != nil {
+		return fmt.Errorf("$ref %s: %v", ref, err)
+	}
+	c.refs[ref] = s
+	return nil
+}
+
+func schemaType(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		if f, err := t.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	}
+	return "unknown"
+}
+
+func schemaEqual(a, b interface{}) bool {
+	fa, okA := schemaNumber(a)
+	fb, okB := schemaNumber(b)
+	if okA && okB {
+		return fa == fb
+	}
+	return schemaType(a) == schemaType(b) && JSONString(a) == JSONString(b)
+}
+
+// Validate returns the first violation in doc (decoded by ParseJSON)
+func (s *JSONSchema) Validate(doc interface{}) error {
+	return s.validate(doc, "$")
+}
+
+func (s *JSONSchema) validate(v interface{}, path string) error {
+	if s.always != nil {
+		if !*s.always {
+			return fmt.Errorf("%s: not allowed", path)
+		}
+		return nil
+	}
+
+	if s.ref != "" {
+		if err := s.refs[s.ref].validate(v, path); err != nil {
+			return err
+		}
+	}
+
+	typ := schemaType(v)
+	if len(s.types) >= 1 {
+		found := false
+		for _, t := range s.types {
+			if t == typ || t == "number" && typ == "integer" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), typ)
+		}
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if schemaEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: not in enum", path)
+		}
+	}
+	if s.hasConst && !schemaEqual(v, s.constant) {
+		return fmt.Errorf("%s: expected %s", path, JSONString(s.constant))
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := t[name]; !ok {
+				return fmt.Errorf("%s: missing %s", path, name)
+			}
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, ok := s.properties[k]
+			if !ok {
+				sub = s.additional
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(t[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(t) < s.minItems || s.maxItems >= 0 && len(t) > s.maxItems {
+			return fmt.Errorf("%s: invalid number of items %d", path, len(t))
+		}
+		for i, item := range t {
+			if s.items != nil {
+				if err := s.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			if s.unique {
+				for _, prev := range t[:i] {
+					if schemaEqual(prev, item) {
+						return fmt.Errorf("%s: duplicated items", path)
+					}
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(t)
+		if n < s.minLength || s.maxLength >= 0 && n > s.maxLength {
+			return fmt.Errorf("%s: invalid length %d", path, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			return fmt.Errorf("%s: not matched %s", path, s.pattern)
+		}
+	case json.Number:
+		f, _ := t.Float64()
+		if s.minimum != nil && f < *s.minimum || s.exMinimum != nil && f <= *s.exMinimum ||
+			s.maximum != nil && f > *s.maximum || s.exMaximum != nil && f >= *s.exMaximum {
+			return fmt.Errorf("%s: %s out of range", path, t)
+		}
+		if s.multipleOf > 0 {
+			q := f / s.multipleOf
+			if math.Abs(q-math.Round(q)) > 1e-9 {
+				return fmt.Errorf("%s: %s is not a multiple of %v", path, t, s.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		found := false
+		for _, sub := range s.anyOf {
+			if sub.validate(v, path) == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: not matched anyOf", path)
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matched += 1
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: matched %d of oneOf", path, matched)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fmt.Errorf("%s: matched not", path)
+	}
+
+	return nil
+}
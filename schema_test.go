@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema, err := CompileJSONSchema([]byte(`{
+		"type": "object",
+		"required": ["id", "name"],
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+			"role": {"enum": ["admin", "user"]},
+			"score": {"type": ["number", "null"], "maximum": 100},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2, "uniqueItems": true},
+			"children": {"type": "array", "items": {"$ref": "#"}}
+		},
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		body string
+		want string
+	}{
+		{`{"id": 1, "name": "a"}`, ""},
+		{`{"id": 1, "name": "a", "role": "user", "score": 99.5, "tags": ["x", "y"]}`, ""},
+		{`{"id": 1, "name": "a", "score": null}`, ""},
+		{`{"id": 1, "name": "a", "children": [{"id": 2, "name": "b"}]}`, ""},
+		{`{"id": 1}`, "$: missing name"},
+		{`{"id": 1.5, "name": "a"}`, "$.id: expected integer, got number"},
+		{`{"id": 0, "name": "a"}`, "$.id: 0 out of range"},
+		{`{"id": 1, "name": "A"}`, "$.name: not matched ^[a-z]+$"},
+		{`{"id": 1, "name": ""}`, "$.name: invalid length 0"},
+		{`{"id": 1, "name": "a", "role": "root"}`, "$.role: not in enum"},
+		{`{"id": 1, "name": "a", "tags": ["x", "x"]}`, "$.tags: duplicated items"},
+		{`{"id": 1, "name": "a", "tags": ["x", 1]}`, "$.tags[1]: expected string, got integer"},
+		{`{"id": 1, "name": "a", "extra": 1}`, "$.extra: not allowed"},
+		{`{"id": 1, "name": "a", "children": [{"id": 2}]}`, "$.children[0]: missing name"},
+		{`[]`, "$: expected object, got array"},
+	}
+	for _, tt := range cases {
+		doc, err := ParseJSON([]byte(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := ""
+		if err := schema.Validate(doc); err != nil {
+			ret = err.Error()
+		}
+		if ret != tt.want {
+			t.Fatalf("%s invalid result: want=%q, ret=%q", tt.body, tt.want, ret)
+		}
+	}
+
+	for _, s := range []string{
+		`1`, `{"type": "foo"}`, `{"$ref": "#/nothing"}`, `{"$ref": "other.json#/a"}`, `{"$ref": 1}`,
+		`{"minLength": -1}`, `{"anyOf": []}`, `{"pattern": "("}`, `{"oneOf": {}}`, `{"uniqueItems": 1}`,
+		`{"format": "email"}`, `{"patternProperties": {"^a": {}}}`, `{"if": {}, "then": {}}`,
+		`{"properties": {"a": {"contains": {}}}}`, `{"items": {"minProperties": 1}}`,
+	} {
+		if _, err := CompileJSONSchema([]byte(s)); err == nil {
+			t.Fatalf("%s: want error", s)
+		}
+	}
+
+	// annotations are allowed
+	if _, err := CompileJSONSchema([]byte(`{"$schema": "http://json-schema.org/draft-07/schema#", "title": "t",
+		"definitions": {"a": {"type": "string"}}, "properties": {"format": {"$ref": "#/definitions/a"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import "math/rand"
+
+// Scope is the variables of a scenario execution (a virtual user). Values
+// extracted from responses are stored here and are not visible from other
+// scenarios, unless the action opts in with "global: true" which stores
+// them into SCANNED_VARS.
+type Scope struct {
+	ExVarOffset map[string]int
+	Vars        map[string][]string
+	VU          *VU
+	// index of the record bound to the scenario by data source
+	Rows map[string]int
+	// the number of scenarios run by the VU before, from 0
+	Iteration int
+	// placeholders left by Replace, cleared by the caller
+	Unresolved []string
+}
+
+// VU is a virtual user running scenarios one after another, from 1
+type VU struct {
+	ID        int
+	Iteration int
+}
+
+func NewScope(offset map[string]int) *Scope {
+	return &Scope{ExVarOffset: offset, Vars: map[string][]string{}}
+}
+
+// store an extracted value into the scope, or into SCANNED_VARS if global
+func (sc *Scope) Set(name, value string, global bool) {
+	sc.SetValues(name, []string{value}, global)
+}
+
+// store extracted values. %(name)% is replaced with one of them at random
+// like vars.
+func (sc *Scope) SetValues(name string, values []string, global bool) {
+	if global {
+		VARS_MUTEX.Lock()
+		SCANNED_VARS[name] = values
+		VARS_MUTEX.Unlock()
+		return
+	}
+	sc.Vars[name] = values
+}
+
+func pick(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values[rand.Intn(len(values))]
+}
+
+// call a generator like uuid(), or resolve a name in order of consts, vars,
+// exvars, data, the scope and the globally scanned vars
+func (sc *Scope) Lookup(name string) (string, bool) {
+	if call, ok := parseCall(name); ok {
+		return sc.call(call)
+	}
+
+	if c, ok := CONSTS[name]; ok {
+		return c, true
+	}
+
+	if v, ok := VARS[name]; ok {
+		return v[rand.Intn(len(v))], true
+	}
+
+	if e, ok := EXVARS[name]; ok && sc.ExVarOffset[name] < len(e.Value) {
+		return e.Value[sc.ExVarOffset[name]], true
+	}
+
+	if v, ok := sc.lookupData(name); ok {
+		return v, true
+	}
+
+	if s, ok := sc.Vars[name]; ok && len(s) >= 1 {
+		return pick(s), true
+	}
+
+	VARS_MUTEX.RLock()
+	s, ok := SCANNED_VARS[name]
+	VARS_MUTEX.RUnlock()
+	if !ok || len(s) == 0 {
+		return "", false
+	}
+	return pick(s), true
+}
+
+// resolve the content of %(...)% with the default and filters
+func (sc *Scope) Resolve(s string) (string, bool) {
+	p := parsePlaceholder(s)
+	v, ok := sc.Lookup(p.name)
+	if !ok {
+		if p.def == nil {
+			return "", false
+		}
+		v = *p.def
+	}
+
+	v, err := applyFilters(v, p.filters)
+	if err != nil {
+		return "", false
+	}
+	return v, true
+}
+
+// replace %(name)% known at this point, leaving the others (even with a
+// default) to a later Replace, e.g. when they are extracted by then
+func (sc *Scope) ReplaceKnown(input string) string {
+	return re.ReplaceAllStringFunc(input, func(s string) string {
+		p := parsePlaceholder(re.FindStringSubmatch(s)[1])
+		v, ok := sc.Lookup(p.name)
+		if !ok {
+			return s
+		}
+		if v, err := applyFilters(v, p.filters); err == nil {
+			return v
+		}
+		return s
+	})
+}
+
+// ReplaceKnown on the values of m
+func (sc *Scope) ReplaceKnownMap(m map[string]string) map[string]string {
+	ret := map[string]string{}
+	for k, v := range m {
+		ret[k] = sc.ReplaceKnown(v)
+	}
+	return ret
+}
+
+// replace %(name)% in input. unknown names are left as they are, and
+// appended to Unresolved.
+func (sc *Scope) Replace(input string) string {
+	return re.ReplaceAllStringFunc(input, func(s string) string {
+		name := re.FindStringSubmatch(s)[1]
+		if v, ok := sc.Resolve(name); ok {
+			return v
+		}
+		sc.Unresolved = append(sc.Unresolved, name)
+		return s
+	})
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector is a subset of CSS selectors for extracting values from HTML:
+// type, *, #id, .class, [attr], [attr=v] (also ~= ^= $= *= |=),
+// :first-child, :last-child, :nth-child(n), descendant and child (>)
+// combinators, and selector lists (a, b).
+type Selector struct {
+	Expr  string
+	group [][]compoundSelector
+}
+
+type compoundSelector struct {
+	combinator byte // ' ' or '>' to the previous compound
+	tag        string
+	id         string
+	classes    []string
+	attrs      []attrSelector
+	nth        int // 1-based, -1 is last-child
+}
+
+type attrSelector struct {
+	name  string
+	op    string
+	value string
+}
+
+func CompileSelector(expr string) (*Selector, error) {
+	sel := &Selector{Expr: expr}
+	for _, part := range splitSelectorList(expr) {
+		complex, err := parseComplexSelector(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", expr, err)
+		}
+		sel.group = append(sel.group, complex)
+	}
+	if len(sel.group) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return sel, nil
+}
+
+// split by "," outside of [] and ()
+func splitSelectorList(s string) (ret []string) {
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth += 1
+		case c == ']' || c == ')':
+			depth -= 1
+		case c == ',' && depth == 0:
+			ret = append(ret, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		ret = append(ret, last)
+	}
+	return ret
+}
+
+func isIdentChar(c byte) bool {
+	return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func parseComplexSelector(s string) (ret []compoundSelector, err error) {
+	combinator := byte(' ')
+	i := 0
+	for i < len(s) {
+		// combinator
+		if s[i] == ' ' || s[i] == '>' {
+			if s[i] == '>' {
+				combinator = '>'
+			}
+			i += 1
+			continue
+		}
+
+		c := compoundSelector{combinator: combinator}
+		combinator = ' '
+		if s[i] == '*' {
+			i += 1
+		}
+		for i < len(s) && s[i] != ' ' && s[i] != '>' {
+			switch {
+			case isIdentChar(s[i]):
+				j := i
+				for j < len(s) && isIdentChar(s[j]) {
+					j += 1
+				}
+				c.tag = strings.ToLower(s[i:j])
+				i = j
+			case s[i] == '#' || s[i] == '.':
+				j := i + 1
+				for j < len(s) && isIdentChar(s[j]) {
+					j += 1
+				}
+				if j == i+1 {
+					return nil, fmt.Errorf("empty name at %d", i)
+				}
+				if s[i] == '#' {
+					c.id = s[i+1 : j]
+				} else {
+					c.classes = append(c.classes, s[i+1:j])
+				}
+				i = j
+			case s[i] == '[':
+				end := strings.IndexByte(s[i:], ']')
+				if end < 0 {
+					return nil, fmt.Errorf("unclosed [")
+				}
+				a, err := parseAttrSelector(s[i+1 : i+end])
+				if err != nil {
+					return nil, err
+				}
+				c.attrs = append(c.attrs, a)
+				i += end + 1
+			case s[i] == ':':
+				j := i + 1
+				for j < len(s) && (isIdentChar(s[j]) || s[j] == '(' || s[j] == ')') {
+					j += 1
+				}
+				pseudo := s[i+1 : j]
+				switch {
+				case pseudo == "first-child":
+					c.nth = 1
+				case pseudo == "last-child":
+					c.nth = -1
+				case strings.HasPrefix(pseudo, "nth-child(") && strings.HasSuffix(pseudo, ")"):
+					n, err := strconv.Atoi(pseudo[len("nth-child(") : len(pseudo)-1])
+					if err != nil || n < 1 {
+						return nil, fmt.Errorf("invalid :%s", pseudo)
+					}
+					c.nth = n
+				default:
+					return nil, fmt.Errorf("unsupported :%s", pseudo)
+				}
+				i = j
+			default:
+				return nil, fmt.Errorf("unexpected %q at %d", s[i], i)
+			}
+		}
+		ret = append(ret, c)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return ret, nil
+}
+
+func parseAttrSelector(s string) (a attrSelector, err error) {
+	idx := strings.IndexByte(s, '=')
+	if idx < 0 {
+		a.name = strings.TrimSpace(s)
+		if a.name == "" {
+			return a, fmt.Errorf("empty attribute")
+		}
+		return a, nil
+	}
+
+	a.op = "="
+	name := s[:idx]
+	if idx >= 1 && strings.IndexByte("~^$*|", s[idx-1]) >= 0 {
+		a.op = s[idx-1 : idx+1]
+		name = s[:idx-1]
+	}
+	a.name = strings.ToLower(strings.TrimSpace(name))
+	a.value = strings.TrimSpace(s[idx+1:])
+	if len(a.value) >= 2 && (a.value[0] == '"' || a.value[0] == '\'') && a.value[len(a.value)-1] == a.value[0] {
+		a.value = a.value[1 : len(a.value)-1]
+	}
+	if a.name == "" {
+		return a, fmt.Errorf("empty attribute")
+	}
+	return a, nil
+}
+
+func getAttr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func (a *attrSelector) match(n *html.Node) bool {
+	v, ok := getAttr(n, a.name)
+	if !ok {
+		return false
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return v == a.value
+	case "~=":
+		for _, f := range strings.Fields(v) {
+			if f == a.value {
+				return true
+			}
+		}
+		return false
+	case "^=":
+		return strings.HasPrefix(v, a.value)
+	case "$=":
+		return strings.HasSuffix(v, a.value)
+	case "*=":
+		return strings.Contains(v, a.value)
+	case "|=":
+		return v == a.value || strings.HasPrefix(v, a.value+"-")
+	}
+	return false
+}
+
+// 1-based position among the element siblings, and whether it is the last
+func elementPosition(n *html.Node) (pos int, last bool) {
+	for s := n; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			pos += 1
+		}
+	}
+	last = true
+	for s := n.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type == html.ElementNode {
+			last = false
+			break
+		}
+	}
+	return pos, last
+}
+
+func (c *compoundSelector) match(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := getAttr(n, "id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) >= 1 {
+		class, _ := getAttr(n, "class")
+		fields := strings.Fields(class)
+		for _, want := range c.classes {
+			found := false
+			for _, f := range fields {
+				if f == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	for i := range c.attrs {
+		if !c.attrs[i].match(n) {
+			return false
+		}
+	}
+	if c.nth != 0 {
+		pos, last := elementPosition(n)
+		if c.nth == -1 && !last || c.nth >= 1 && pos != c.nth {
+			return false
+		}
+	}
+	return true
+}
+
+// match compounds[:i+1] with n as the subject of compounds[i]
+func matchComplex(compounds []compoundSelector, i int, n *html.Node) bool {
+	if !compounds[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if compounds[i].combinator == '>' {
+		return n.Parent != nil && matchComplex(compounds, i-1, n.Parent)
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if matchComplex(compounds, i-1, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns matched elements in document order
+func (sel *Selector) Find(doc *html.Node) (ret []*html.Node) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for _, complex := range sel.group {
+			if matchComplex(complex, len(complex)-1, n) {
+				ret = append(ret, n)
+				break
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return ret
+}
+
+// text content of the node
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSelector(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+		<form id="login" class="form main">
+			<input type="hidden" name="csrf" value="tok123">
+			<input type="text" name="user" value="">
+		</form>
+		<ul class="items">
+			<li><a href="/items/1" data-id="1">one</a></li>
+			<li><a href="/items/2" data-id="2">two</a></li>
+			<li><span><a href="/other">other</a></span></li>
+		</ul>
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		expr string
+		attr string
+		want []string
+	}{
+		{"input[name=csrf]", "value", []string{"tok123"}},
+		{`input[name="csrf"]`, "value", []string{"tok123"}},
+		{"#login input[type=text]", "name", []string{"user"}},
+		{"form.form.main > input", "name", []string{"csrf", "user"}},
+		{"ul.items a", "href", []string{"/items/1", "/items/2", "/other"}},
+		{"ul.items > li > a", "", []string{"one", "two"}},
+		{"a[href^=/items/]", "data-id", []string{"1", "2"}},
+		{"li:first-child a, li:last-child a", "", []string{"one", "other"}},
+		{"li:nth-child(2) a", "", []string{"two"}},
+		{"*[data-id$='2']", "", []string{"two"}},
+		{"table td", "", nil},
+	}
+	for _, tt := range cases {
+		sel, err := CompileSelector(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		var ret []string
+		for _, n := range sel.Find(doc) {
+			if tt.attr == "" {
+				ret = append(ret, nodeText(n))
+			} else if v, ok := getAttr(n, tt.attr); ok {
+				ret = append(ret, v)
+			}
+		}
+		if !reflect.DeepEqual(ret, tt.want) {
+			t.Fatalf("%s invalid result: want=%v, ret=%v", tt.expr, tt.want, ret)
+		}
+	}
+
+	for _, expr := range []string{"", "input[name", "li:hover", "a..b"} {
+		if _, err := CompileSelector(expr); err == nil {
+			t.Fatalf("%q: want error", expr)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage is a step of the load profile. The concurrency changes linearly
+// from the target of the previous stage (0 for the first stage) to Target
+// over Duration. A stage with "0s" duration jumps to Target immediately.
+type Stage struct {
+	Name     string `yaml:"name"`
+	Duration string `yaml:"duration"`
+	Target   int    `yaml:"target"`
+	duration time.Duration
+}
+
+// index of the running stage, -1 when stages are not used
+var STAGE int32 = -1
+
+func (c *Config) loadStages() error {
+	for i := range c.Stages {
+		d, err := time.ParseDuration(c.Stages[i].Duration)
+		if err != nil {
+			return fmt.Errorf("stages[%d]: %v", i, err)
+		}
+		c.Stages[i].duration = d
+		if c.Stages[i].Target < 0 {
+			return fmt.Errorf("stages[%d]: negative target %d", i, c.Stages[i].Target)
+		}
+		if c.Stages[i].Name == "" {
+			c.Stages[i].Name = fmt.Sprintf("stage%d", i+1)
+		}
+	}
+
+	if len(c.Stages) >= 1 && c.Rate > 0 {
+		return fmt.Errorf("stages and rate can not be used at the same time")
+	}
+
+	return nil
+}
+
+// return the time offset where the stage starts
+func stageStart(stages []Stage, idx int) (start time.Duration) {
+	for i := 0; i < idx; i++ {
+		start += stages[i].duration
+	}
+	return start
+}
+
+// return the target concurrency and the index of the stage at elapsed.
+// idx is -1 after the last stage.
+func stageTarget(stages []Stage, elapsed time.Duration) (target int, idx int) {
+	from := 0
+	for i, st := range stages {
+		if elapsed < st.duration {
+			ratio := float64(elapsed) / float64(st.duration)
+			return from + int(float64(st.Target-from)*ratio), i
+		}
+		elapsed -= st.duration
+		from = st.Target
+	}
+
+	return 0, -1
+}
+
+func stageWorker(ctx, stop context.Context, cancel context.CancelFunc, id int, target *int32, active []int32, config *Config, wg *sync.WaitGroup) {
+	defer wg.Done()
+	vu := &VU{ID: id + 1}
+	for int32(id) < atomic.LoadInt32(target) && stop.Err() == nil {
+		hakai(ctx, stop, cancel, client, config, vu)
+	}
+	atomic.StoreInt32(&active[id], 0)
+}
+
+// the max concurrency of the stages
+func (c *Config) maxTarget() (max int) {
+	for _, st := range c.Stages {
+		if st.Target > max {
+			max = st.Target
+		}
+	}
+	return max
+}
+
+// grow and shrink the worker pool following config.Stages
+func stageMain(ctx, stop context.Context, cancel context.CancelFunc, config *Config) {
+	var wg sync.WaitGroup
+	var target int32
+
+	active := make([]int32, config.maxTarget())
+
+	start := time.Now()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		t, idx := stageTarget(config.Stages, time.Since(start))
+		atomic.StoreInt32(&target, int32(t))
+		if idx < 0 {
+			break
+		}
+		atomic.StoreInt32(&STAGE, int32(idx))
+
+		for id := 0; id < t; id++ {
+			if atomic.CompareAndSwapInt32(&active[id], 0, 1) {
+				wg.Add(1)
+				go stageWorker(ctx, stop, cancel, id, &target, active, config, &wg)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop.Done():
+			wg.Wait()
+			return
+		}
+	}
+
+	wg.Wait()
+}
@@ -5,9 +5,10 @@ import (
 	"encoding/gob"
 	"fmt"
 	"log"
-	"os"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,15 +17,110 @@ type Statistics struct {
 	StartTime  time.Time
 	Delta      time.Duration
 	Config     *Config
+
+	// built once when the attack is finished, thresholds are evaluated in it
+	report *Report
 }
 
 type NodeStats struct {
-	Success     uint32
-	Fail        uint32
-	Concurrency int
-	Time        time.Duration
-	PathCount   map[string]uint32
-	PathTime    map[string]time.Duration
+	Success       uint32
+	Fail          uint32
+	Dropped       uint32
+	Interrupted   uint32
+	Concurrency   int
+	Time          time.Duration
+	StartTime     time.Time
+	EndTime       time.Time
+	PathCount     map[string]uint32
+	PathTime      map[string]time.Duration
+	PathHist      map[string]*Histogram
+	PathPhase     map[string]*PhaseStats
+	PathFail      map[string]uint32
+	PathReqs      map[string]uint32
+	StatusCount   map[int]uint32
+	FailCount     map[string]uint32
+	TimeSeries    map[int64]*TimeBucket
+	StageHist     map[int]*Histogram
+	ScenarioCount map[string]uint32
+	ScenarioFail  map[string]uint32
+	ScenarioHist  map[string]*Histogram
+	PollHist      map[string]*Histogram
+	PollTimeout   map[string]uint32
+}
+
+// TimeBucket is the result within one second (keyed by unix time)
+type TimeBucket struct {
+	Requests  uint32
+	Fail      uint32
+	Responses uint32
+	Sum       time.Duration
+	Max       time.Duration
+	Scenarios int32
+	Stage     int32
+}
+
+func (b *TimeBucket) Merge(o *TimeBucket) {
+	b.Requests += o.Requests
+	b.Fail += o.Fail
+	b.Responses += o.Responses
+	b.Sum += o.Sum
+	if o.Max > b.Max {
+		b.Max = o.Max
+	}
+	b.Scenarios += o.Scenarios
+	if o.Stage > b.Stage {
+		b.Stage = o.Stage
+	}
+}
+
+// record a request to the time series and the running stage.
+// diffTime is 0 when no response.
+func recordTick(success bool, diffTime time.Duration) {
+	now := time.Now().Unix()
+	stage := atomic.LoadInt32(&STAGE)
+
+	m.Lock()
+	defer m.Unlock()
+
+	b, ok := TimeSeries[now]
+	if !ok {
+		b = &TimeBucket{Stage: stage}
+		TimeSeries[now] = b
+	}
+	b.Requests += 1
+	if !success {
+		b.Fail += 1
+	}
+	if diffTime > 0 {
+		b.Responses += 1
+		b.Sum += diffTime
+		if diffTime > b.Max {
+			b.Max = diffTime
+		}
+	}
+	if active := atomic.LoadInt32(&ACTIVE); active > b.Scenarios {
+		b.Scenarios = active
+	}
+
+	if stage >= 0 && diffTime > 0 {
+		if _, ok := StageHist[int(stage)]; !ok {
+			StageHist[int(stage)] = NewHistogram()
+		}
+		StageHist[int(stage)].Record(diffTime)
+	}
+}
+
+// count the request by action, and the failure by each reason
+func recordResult(key string, success bool, reasons []string) {
+	m.Lock()
+	PathRequests[key] += 1
+	if !success {
+		PathFail[key] += 1
+		for _, reason := range reasons {
+			FailCount[reason] += 1
+		}
+	}
+	m.Unlock()
 }
 
 type AvarageTimeByPath struct {
@@ -43,23 +139,34 @@ func (s AvarageTimeStats) Less(i, j int) bool {
 	return s[i].Time < s[j].Time
 }
 
-func (s *Statistics) PrintAfterDuration(duration int) {
-	time.Sleep(time.Duration(duration) * time.Second)
-	s.Print()
-	os.Exit(0)
-}
-
 func (s *Statistics) printGob() {
 	delta := s.Delta
 
 	var buf bytes.Buffer
 	var n NodeStats = NodeStats{
-		Success:     SUCCESS,
-		Fail:        FAIL,
-		Concurrency: s.MaxRequest,
-		Time:        delta,
-		PathCount:   PathCount,
-		PathTime:    PathTime,
+		Success:       SUCCESS,
+		Fail:          FAIL,
+		Dropped:       DROPPED,
+		Interrupted:   INTERRUPTED,
+		Concurrency:   s.MaxRequest,
+		Time:          delta,
+		StartTime:     s.StartTime,
+		EndTime:       s.StartTime.Add(delta),
+		PathCount:     PathCount,
+		PathTime:      PathTime,
+		PathHist:      PathHist,
+		PathPhase:     PathPhase,
+		PathFail:      PathFail,
+		PathReqs:      PathRequests,
+		StatusCount:   StatusCount,
+		FailCount:     FailCount,
+		TimeSeries:    TimeSeries,
+		StageHist:     StageHist,
+		ScenarioCount: ScenarioCount,
+		ScenarioFail:  ScenarioFail,
+		ScenarioHist:  ScenarioHist,
+		PollHist:      PollHist,
+		PollTimeout:   PollTimeout,
 	}
 	enc := gob.NewEncoder(&buf)
 	err := enc.Encode(n)
@@ -79,6 +186,29 @@ func (s *Statistics) printHumanReadable() {
 		nreq, s.MaxRequest, delta.Seconds(), rps)
 	fmt.Printf("SUCCESS %d\n", SUCCESS)
 	fmt.Printf("FAILED %d\n", FAIL)
+	if INTERRUPTED >= 1 {
+		fmt.Printf("INTERRUPTED %d (canceled in-flight requests)\n", INTERRUPTED)
+	}
+	if s.Config.Rate > 0 {
+		fmt.Printf("DROPPED %d (arrivals while %d scenarios in flight)\n", DROPPED, s.MaxRequest)
+	}
+
+	codes := []int{}
+	for code := range StatusCount {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Printf("HTTP %d: %d\n", code, StatusCount[code])
+	}
+	reasons := []string{}
+	for reason := range FailCount {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("FAILED (%s): %d\n", reason, FailCount[reason])
+	}
 
 	var avgTimeByPath map[string]float64 = map[string]float64{}
 	var totalCount uint32
@@ -91,18 +221,139 @@ func (s *Statistics) printHumanReadable() {
 	fmt.Printf("Average response time[ms]: %v\n",
 		1000.*totalTime.Seconds()/float64(totalCount))
 
+	fmt.Printf("Response time percentiles[ms]: %s\n", formatPercentiles(totalHistogram()))
+
+	phase := NewPhaseStats()
+	for _, p := range PathPhase {
+		phase.Merge(p)
+	}
+	fmt.Printf("Timing breakdown (avg/p95)[ms]: %s\n", phase)
+
+	if len(s.Config.Stages) >= 1 {
+		s.printStages()
+	}
+
+	if len(s.Config.Scenarios) >= 1 {
+		s.printScenarios()
+	}
+
+	if len(PollHist) >= 1 || len(PollTimeout) >= 1 {
+		s.printPolls()
+	}
+
 	if s.Config.ShowReport {
 		var stats AvarageTimeStats = []AvarageTimeByPath{}
 
-		fmt.Printf("Average response time for each path (order by longest) [ms]:\n")
+		fmt.Printf("Response time for each action (order by longest average) [ms]:\n")
 		for path, time := range avgTimeByPath {
 			stats = append(stats, AvarageTimeByPath{Path: path, Time: time})
 		}
 		sort.Sort(sort.Reverse(stats))
 		for i := 0; i < len(stats); i++ {
 			fmt.Printf("%.3f : %s\n", stats[i].Time*1000., stats[i].Path)
+			if h, ok := PathHist[stats[i].Path]; ok {
+				fmt.Printf("\t%s\n", formatPercentiles(h))
+			}
+			if p, ok := PathPhase[stats[i].Path]; ok {
+				fmt.Printf("\t%s\n", p)
+			}
 		}
+
+		s.printTimeSeries()
 	}
+
+	if len(s.Config.thresholds) >= 1 {
+		s.printThresholds(s.Report().Thresholds)
+	}
+}
+
+// merged histogram of all actions
+func totalHistogram() *Histogram {
+	total := NewHistogram()
+	for _, h := range PathHist {
+		total.Merge(h)
+	}
+	return total
+}
+
+func (s *Statistics) printStages() {
+	requests := map[int32]uint32{}
+	fails := map[int32]uint32{}
+	for _, b := range TimeSeries {
+		requests[b.Stage] += b.Requests
+		fails[b.Stage] += b.Fail
+	}
+
+	fmt.Printf("Stages:\n")
+	for i, st := range s.Config.Stages {
+		start := stageStart(s.Config.Stages, i)
+		fmt.Printf("%s (%v-%v, target:%d) request count:%d, failed:%d\n",
+			st.Name, start, start+st.duration, st.Target,
+			requests[int32(i)], fails[int32(i)])
+		if h, ok := StageHist[i]; ok {
+			fmt.Printf("\t%s\n", formatPercentiles(h))
+		}
+	}
+}
+
+// print the time series by interval seconds, at most 60 rows
+func (s *Statistics) printTimeSeries() {
+	if len(TimeSeries) == 0 {
+		return
+	}
+
+	var first, last int64
+	for sec := range TimeSeries {
+		if first == 0 || sec < first {
+			first = sec
+		}
+		if sec > last {
+			last = sec
+		}
+	}
+	interval := (last-first)/60 + 1
+
+	fmt.Printf("Time series (every %d[s]):\n", interval)
+	fmt.Printf("%8s %10s %8s %10s %10s %9s\n",
+		"time[s]", "req/s", "failed", "avg[ms]", "max[ms]", "scenarios")
+	stage := int32(-1)
+	for t := first; t <= last; t += interval {
+		row := TimeBucket{Stage: -1}
+		var scenarios int32
+		for sec := t; sec < t+interval && sec <= last; sec++ {
+			if b, ok := TimeSeries[sec]; ok {
+				row.Merge(b)
+				if b.Scenarios > scenarios {
+					scenarios = b.Scenarios
+				}
+			}
+		}
+		if row.Stage > stage && int(row.Stage) < len(s.Config.Stages) {
+			stage = row.Stage
+			fmt.Printf("--- %s ---\n", s.Config.Stages[stage].Name)
+		}
+		var avg time.Duration
+		if row.Responses >= 1 {
+			avg = row.Sum / time.Duration(row.Responses)
+		}
+		fmt.Printf("%8d %10.1f %8d %10.3f %10.3f %9d\n",
+			t-first, float64(row.Requests)/float64(interval), row.Fail,
+			msec(avg), msec(row.Max), scenarios)
+	}
+}
+
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// "p50=1.234 p90=... max=..."
+func formatPercentiles(h *Histogram) string {
+	var b strings.Builder
+	for _, p := range histPercentiles {
+		fmt.Fprintf(&b, "p%v=%.3f ", p, msec(h.Percentile(p)))
+	}
+	fmt.Fprintf(&b, "max=%.3f", msec(h.Max))
+	return b.String()
 }
 
 func (s *Statistics) Print() {
@@ -125,19 +376,87 @@ func parseResultGob(s string) (result NodeStats, err error) {
 	return
 }
 
+// Collector merges the results of every node. Elapsed time is taken from the
+// earliest start and the latest end across nodes (wall-clock), not the sum.
 func (s *Statistics) Collector(c chan string, wg *sync.WaitGroup) {
+	var endTime time.Time
 	s.Delta = time.Duration(0)
 	for {
 		ret := <-c
 		n, _ := parseResultGob(ret)
 		SUCCESS += n.Success
 		FAIL += n.Fail
+		DROPPED += n.Dropped
+		INTERRUPTED += n.Interrupted
 		s.MaxRequest += n.Concurrency
-		s.Delta += n.Time
+		if s.StartTime.IsZero() || n.StartTime.Before(s.StartTime) {
+			s.StartTime = n.StartTime
+		}
+		if n.EndTime.After(endTime) {
+			endTime = n.EndTime
+		}
+		s.Delta = endTime.Sub(s.StartTime)
 		for path, cnt := range n.PathCount {
 			PathTime[path] += n.PathTime[path]
 			PathCount[path] += cnt
 		}
+		for path, h := range n.PathHist {
+			if _, ok := PathHist[path]; !ok {
+				PathHist[path] = NewHistogram()
+			}
+			PathHist[path].Merge(h)
+		}
+		for path, p := range n.PathPhase {
+			if _, ok := PathPhase[path]; !ok {
+				PathPhase[path] = NewPhaseStats()
+			}
+			PathPhase[path].Merge(p)
+		}
+		for path, cnt := range n.PathFail {
+			PathFail[path] += cnt
+		}
+		for path, cnt := range n.PathReqs {
+			PathRequests[path] += cnt
+		}
+		for code, cnt := range n.StatusCount {
+			StatusCount[code] += cnt
+		}
+		for reason, cnt := range n.FailCount {
+			FailCount[reason] += cnt
+		}
+		for sec, b := range n.TimeSeries {
+			if _, ok := TimeSeries[sec]; !ok {
+				TimeSeries[sec] = &TimeBucket{Stage: -1}
+			}
+			TimeSeries[sec].Merge(b)
+		}
+		for stage, h := range n.StageHist {
+			if _, ok := StageHist[stage]; !ok {
+				StageHist[stage] = NewHistogram()
+			}
+			StageHist[stage].Merge(h)
+		}
+		for name, cnt := range n.ScenarioCount {
+			ScenarioCount[name] += cnt
+		}
+		for name, cnt := range n.ScenarioFail {
+			ScenarioFail[name] += cnt
+		}
+		for name, h := range n.ScenarioHist {
+			if _, ok := ScenarioHist[name]; !ok {
+				ScenarioHist[name] = NewHistogram()
+			}
+			ScenarioHist[name].Merge(h)
+		}
+		for key, h := range n.PollHist {
+			if _, ok := PollHist[key]; !ok {
+				PollHist[key] = NewHistogram()
+			}
+			PollHist[key].Merge(h)
+		}
+		for key, cnt := range n.PollTimeout {
+			PollTimeout[key] += cnt
+		}
 		wg.Done()
 	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	THINK_FIXED       = "fixed"
+	THINK_UNIFORM     = "uniform"
+	THINK_NORMAL      = "normal"
+	THINK_EXPONENTIAL = "exponential"
+)
+
+// ThinkTime is the wait after an action, like a user reading the page.
+//
+//	think_time: 1s                                     # fixed
+//	think_time: {dist: uniform, min: 1s, max: 3s}
+//	think_time: {dist: normal, mean: 2s, stddev: 500ms}
+//	think_time: {dist: exponential, mean: 2s}
+type ThinkTime struct {
+	Dist   string
+	Min    time.Duration
+	Max    time.Duration
+	Mean   time.Duration
+	StdDev time.Duration
+}
+
+// Next returns a duration by the distribution, never negative
+func (t *ThinkTime) Next() time.Duration {
+	var d time.Duration
+	switch t.Dist {
+	case THINK_UNIFORM:
+		d = t.Min + time.Duration(rand.Int63n(int64(t.Max-t.Min)+1))
+	case THINK_NORMAL:
+		d = t.Mean + time.Duration(rand.NormFloat64()*float64(t.StdDev))
+	case THINK_EXPONENTIAL:
+		d = time.Duration(rand.ExpFloat64() * float64(t.Mean))
+	default:
+		d = t.Mean
+	}
+	return time.Duration(math.Max(0, float64(d)))
+}
+
+func compileThinkTime(spec interface{}) (*ThinkTime, error) {
+	if s, ok := spec.(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("think_time: invalid duration %s", s)
+		}
+		return &ThinkTime{Dist: THINK_FIXED, Mean: d}, nil
+	}
+
+	opts, err := toStringMap(spec)
+	if err != nil {
+		return nil, fmt.Errorf("think_time %v", err)
+	}
+
+	t := &ThinkTime{Dist: THINK_FIXED}
+	for k, v := range opts {
+		if k == "dist" {
+			t.Dist = fmt.Sprint(v)
+			continue
+		}
+
+		d, err := time.ParseDuration(fmt.Sprint(v))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("think_time: invalid %s %v", k, v)
+		}
+		switch k {
+		case "min":
+			t.Min = d
+		case "max":
+			t.Max = d
+		case "mean", "value":
+			t.Mean = d
+		case "stddev":
+			t.StdDev = d
+		default:
+			return nil, fmt.Errorf("think_time: unknown option %s", k)
+		}
+	}
+
+	switch t.Dist {
+	case THINK_FIXED, THINK_NORMAL, THINK_EXPONENTIAL:
+	case THINK_UNIFORM:
+		if t.Max < t.Min {
+			return nil, fmt.Errorf("think_time: max is less than min")
+		}
+	default:
+		return nil, fmt.Errorf("think_time: unknown dist %s", t.Dist)
+	}
+	return t, nil
+}
+
+// wait for d unless ctx is done. return false when ctx is done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestThinkTime(t *testing.T) {
+	cases := []struct {
+		spec     string
+		min, max time.Duration
+	}{
+		{`500ms`, 500 * time.Millisecond, 500 * time.Millisecond},
+		{`{dist: uniform, min: 1s, max: 2s}`, time.Second, 2 * time.Second},
+		{`{dist: normal, mean: 1s, stddev: 0s}`, time.Second, time.Second},
+		{`{dist: normal, mean: 10ms, stddev: 1s}`, 0, time.Hour},
+		{`{dist: exponential, mean: 1s}`, 0, time.Hour},
+	}
+	for _, tt := range cases {
+		var spec interface{}
+		if err := yaml.Unmarshal([]byte(tt.spec), &spec); err != nil {
+			t.Fatal(err)
+		}
+		think, err := compileThinkTime(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		for i := 0; i < 100; i++ {
+			if d := think.Next(); d < tt.min || d > tt.max {
+				t.Fatalf("%s: out of range %v", tt.spec, d)
+			}
+		}
+	}
+
+	for _, s := range []string{`-1s`, `abc`, `{dist: foo}`, `{dist: uniform, min: 2s, max: 1s}`, `{mean: x}`, `{foo: 1s}`} {
+		var spec interface{}
+		yaml.Unmarshal([]byte(s), &spec)
+		if _, err := compileThinkTime(spec); err == nil {
+			t.Fatalf("%s: want error", s)
+		}
+	}
+}
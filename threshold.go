@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exit status when any threshold is not passed
+const EXIT_THRESHOLD_FAILED = 99
+
+// Threshold is a pass/fail criterion evaluated after the run, written as
+// "metric[(action)] op value" e.g. "error_rate < 1%", "p95(login) < 300ms",
+// "rps > 1000".
+//
+// metrics: requests, success, failed, dropped, error_rate, rps,
+// avg, min, max, pNN (latency), poll_timeout (timeouts of polling)
+type Threshold struct {
+	Expr   string
+	Metric string
+	Action string
+	Op     string
+	Value  float64
+}
+
+type ThresholdResult struct {
+	Expr   string  `json:"expr"`
+	Actual float64 `json:"actual"`
+	Pass   bool    `json:"pass"`
+	Error  string  `json:"error,omitempty"`
+
+	// formatted Actual with the unit
+	display string
+}
+
+var thresholdRe = regexp.MustCompile(`^\s*([a-z_]+|p[0-9.]+)\s*(?:\(\s*(.+?)\s*\))?\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+func isLatencyMetric(metric string) bool {
+	switch metric {
+	case "avg", "min", "max":
+		return true
+	}
+	// pNN
+	return len(metric) >= 2 && metric[0] == 'p' && metric[1] >= '0' && metric[1] <= '9'
+}
+
+func parseThreshold(expr string) (t Threshold, err error) {
+	m := thresholdRe.FindStringSubmatch(expr)
+	if m == nil {
+		return t, fmt.Errorf("invalid threshold: %s", expr)
+	}
+	t = Threshold{Expr: expr, Metric: m[1], Action: m[2], Op: m[3]}
+
+	switch {
+	case isLatencyMetric(t.Metric):
+		if strings.HasPrefix(t.Metric, "p") {
+			if _, err := strconv.ParseFloat(t.Metric[1:], 64); err != nil {
+				return t, fmt.Errorf("invalid percentile: %s", expr)
+			}
+		}
+		// in milliseconds
+		d, err := time.ParseDuration(m[4])
+		if err != nil {
+			return t, fmt.Errorf("invalid duration: %s", expr)
+		}
+		t.Value = msec(d)
+	case t.Metric == "error_rate":
+		v := strings.TrimSuffix(m[4], "%")
+		t.Value, err = strconv.ParseFloat(v, 64)
+		if v != m[4] {
+			t.Value /= 100.
+		}
+	case t.Metric == "rps" || t.Metric == "requests" || t.Metric == "success" ||
+		t.Metric == "failed" || t.Metric == "dropped" || t.Metric == "poll_timeout":
+		t.Value, err = strconv.ParseFloat(m[4], 64)
+	default:
+		return t, fmt.Errorf("unknown metric: %s", expr)
+	}
+	if err != nil {
+		return t, fmt.Errorf("invalid value: %s", expr)
+	}
+
+	return t, nil
+}
+
+// return the value of the metric. ok is false if there is no such action.
+func (t *Threshold) actual(r *Report) (v float64, ok bool) {
+	if t.Metric == "poll_timeout" {
+		return t.pollTimeout(r)
+	}
+
+	requests, failed := r.Requests, r.Failed
+	latency := r.Latency
+	if t.Action != "" {
+		found := false
+		for _, a := range r.Actions {
+			if a.Name == t.Action {
+				requests, failed, latency = a.Requests, a.Failed, a.Latency
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+
+	switch t.Metric {
+	case "requests":
+		return float64(requests), true
+	case "success":
+		return float64(requests - failed), true
+	case "failed":
+		return float64(failed), true
+	case "dropped":
+		return float64(r.Dropped), true
+	case "error_rate":
+		if requests == 0 {
+			return 0, true
+		}
+		return float64(failed) / float64(requests), true
+	case "rps":
+		if r.Duration == 0 {
+			return 0, true
+		}
+		return float64(requests) / r.Duration, true
+	case "avg":
+		return latency.Avg, true
+	case "min":
+		return latency.Min, true
+	case "max":
+		return latency.Max, true
+	}
+
+	if v, ok := latency.Percentiles[t.Metric]; ok {
+		return v, true
+	}
+	p, _ := strconv.ParseFloat(t.Metric[1:], 64)
+	if t.Action == "" {
+		return msec(totalHistogram().Percentile(p)), true
+	}
+	h, ok := PathHist[t.Action]
+	if !ok {
+		return 0, true
+	}
+	return msec(h.Percentile(p)), true
+}
+
+// timeouts of all polling actions, or of the action
+func (t *Threshold) pollTimeout(r *Report) (v float64, ok bool) {
+	for _, p := range r.Polls {
+		if t.Action == "" || p.Name == t.Action {
+			v += float64(p.Timeout)
+			ok = true
+		}
+	}
+	return v, ok || t.Action == ""
+}
+
+func (t *Threshold) Eval(r *Report) ThresholdResult {
+	ret := ThresholdResult{Expr: t.Expr}
+	v, ok := t.actual(r)
+	if !ok {
+		ret.Error = fmt.Sprintf("no such action: %s", t.Action)
+		return ret
+	}
+	ret.Actual = v
+	ret.display = t.format(v)
+
+	switch t.Op {
+	case "<":
+		ret.Pass = v < t.Value
+	case "<=":
+		ret.Pass = v <= t.Value
+	case ">":
+		ret.Pass = v > t.Value
+	case ">=":
+		ret.Pass = v >= t.Value
+	case "==":
+		ret.Pass = v == t.Value
+	case "!=":
+		ret.Pass = v != t.Value
+	}
+
+	return ret
+}
+
+func (t *Threshold) format(v float64) string {
+	switch {
+	case t.Metric == "error_rate":
+		return fmt.Sprintf("%.3f%%", v*100.)
+	case isLatencyMetric(t.Metric):
+		return fmt.Sprintf("%.3fms", v)
+	case t.Metric == "rps":
+		return fmt.Sprintf("%.3f", v)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
+func (c *Config) loadThresholds() error {
+	c.thresholds = []Threshold{}
+	for _, expr := range c.Thresholds {
+		t, err := parseThreshold(expr)
+		if err != nil {
+			return err
+		}
+		c.thresholds = append(c.thresholds, t)
+	}
+	return nil
+}
+
+func (s *Statistics) evalThresholds(r *Report) (results []ThresholdResult) {
+	for _, t := range s.Config.thresholds {
+		results = append(results, t.Eval(r))
+	}
+	return results
+}
+
+// print the pass/fail table
+func (s *Statistics) printThresholds(results []ThresholdResult) {
+	fmt.Printf("Thresholds:\n")
+	for _, ret := range results {
+		status := "PASS"
+		if !ret.Pass {
+			status = "FAIL"
+		}
+		actual := ret.display
+		if ret.Error != "" {
+			actual = ret.Error
+		}
+		fmt.Printf("  %s  %-30s actual: %s\n", status, ret.Expr, actual)
+	}
+}
+
+// ThresholdsPassed returns whether all thresholds of the report are passed
+func (s *Statistics) ThresholdsPassed() bool {
+	for _, ret := range s.Report().Thresholds {
+		if !ret.Pass {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	cases := []struct {
+		expr   string
+		metric string
+		action string
+		op     string
+		value  float64
+	}{
+		{"error_rate < 1%", "error_rate", "", "<", 0.01},
+		{"error_rate <= 0.05", "error_rate", "", "<=", 0.05},
+		{"p95(login) < 300ms", "p95", "login", "<", 300},
+		{"p99.9 < 1s", "p99.9", "", "<", 1000},
+		{"rps > 1000", "rps", "", ">", 1000},
+		{"failed(/users/:id) == 0", "failed", "/users/:id", "==", 0},
+		{"poll_timeout(job status) == 0", "poll_timeout", "job status", "==", 0},
+	}
+	for _, tt := range cases {
+		ret, err := parseThreshold(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if ret.Metric != tt.metric || ret.Action != tt.action || ret.Op != tt.op || ret.Value != tt.value {
+			t.Fatalf("%s invalid result: %+v", tt.expr, ret)
+		}
+	}
+
+	for _, expr := range []string{"unknown < 1", "p95 < 300", "rps >", "error_rate ~ 1%"} {
+		if _, err := parseThreshold(expr); err == nil {
+			t.Fatalf("%s: want error", expr)
+		}
+	}
+}
+
+func TestLoadInvalidThreshold(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "thresholds.yml")
+	yml := "thresholds:\n    - error_rate < 1%\n    - p95 < fast\n"
+	if err := os.WriteFile(filename, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{}
+	if err := config.Load(filename); err == nil {
+		t.Fatal("invalid threshold is loaded")
+	}
+}
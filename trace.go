@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// requestTrace records the time of each phase of a request via httptrace.
+// Callbacks may be called from the transport's goroutines.
+type requestTrace struct {
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	bodyDone     time.Time
+	reused       bool
+	sync.Mutex
+}
+
+func (t *requestTrace) set(p *time.Time) {
+	t.Lock()
+	*p = time.Now()
+	t.Unlock()
+}
+
+func (t *requestTrace) WithTrace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart:      func(string, string) { t.set(&t.connectStart) },
+		ConnectDone:       func(string, string, error) { t.set(&t.connectDone) },
+		TLSHandshakeStart: func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.set(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.Lock()
+			t.reused = info.Reused
+			t.Unlock()
+		},
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// PhaseStats is the timing breakdown of the requests of a path.
+// DNS, Connect and TLS are recorded only when a new connection is made.
+type PhaseStats struct {
+	DNS      *Histogram
+	Connect  *Histogram
+	TLS      *Histogram
+	TTFB     *Histogram
+	Transfer *Histogram
+	Reused   uint32
+}
+
+func NewPhaseStats() *PhaseStats {
+	return &PhaseStats{
+		DNS:      NewHistogram(),
+		Connect:  NewHistogram(),
+		TLS:      NewHistogram(),
+		TTFB:     NewHistogram(),
+		Transfer: NewHistogram(),
+	}
+}
+
+func (p *PhaseStats) Record(t *requestTrace) {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.dnsDone.IsZero() {
+		p.DNS.Record(t.dnsDone.Sub(t.dnsStart))
+	}
+	if !t.connectDone.IsZero() {
+		p.Connect.Record(t.connectDone.Sub(t.connectStart))
+	}
+	if !t.tlsDone.IsZero() {
+		p.TLS.Record(t.tlsDone.Sub(t.tlsStart))
+	}
+	if !t.firstByte.IsZero() {
+		p.TTFB.Record(t.firstByte.Sub(t.start))
+		if !t.bodyDone.IsZero() {
+			p.Transfer.Record(t.bodyDone.Sub(t.firstByte))
+		}
+	}
+	if t.reused {
+		p.Reused += 1
+	}
+}
+
+func (p *PhaseStats) Merge(o *PhaseStats) {
+	p.DNS.Merge(o.DNS)
+	p.Connect.Merge(o.Connect)
+	p.TLS.Merge(o.TLS)
+	p.TTFB.Merge(o.TTFB)
+	p.Transfer.Merge(o.Transfer)
+	p.Reused += o.Reused
+}
+
+// "dns=0.123/0.456 connect=... reused=98.0%" (avg/p95 [ms])
+func (p *PhaseStats) String() string {
+	phases := []struct {
+		name string
+		h    *Histogram
+	}{
+		{"dns", p.DNS},
+		{"connect", p.Connect},
+		{"tls", p.TLS},
+		{"ttfb", p.TTFB},
+		{"transfer", p.Transfer},
+	}
+
+	ret := ""
+	for _, ph := range phases {
+		if ph.h.Total == 0 {
+			continue
+		}
+		ret += fmt.Sprintf("%s=%.3f/%.3f ", ph.name, msec(ph.h.Mean()), msec(ph.h.Percentile(95)))
+	}
+	var reused float64
+	if p.TTFB.Total >= 1 {
+		reused = 100. * float64(p.Reused) / float64(p.TTFB.Total)
+	}
+	ret += fmt.Sprintf("reused=%.1f%%", reused)
+
+	return ret
+}